@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// backfill fetches every killmail from the days between from and to,
+// inclusive, and writes them through the same sink, checkpoint and
+// dead-letter file as read. Completed days are recorded in Archive.backfill so
+// an interrupted backfill resumes where it stopped. It shouldn't run at the
+// same time as a read with the same Archive, since both save the checkpoint.
+func backfill(spec Specification, args []string) {
+	if len(args) != 2 {
+		fmt.Println("usage: backfill <from YYYY-MM-DD> <to YYYY-MM-DD>")
+		os.Exit(1)
+	}
+	from, err := time.Parse("2006-01-02", args[0])
+	if err != nil {
+		panic(err)
+	}
+	to, err := time.Parse("2006-01-02", args[1])
+	if err != nil {
+		panic(err)
+	}
+	if spec.Backfill_Rate <= 0 {
+		panic("BACKFILL_RATE must be positive")
+	}
+
+	r := makeReader(spec)
+	defer r.Close()
+	rand.Seed(time.Now().UnixNano())
+	b := &backfiller{
+		client:  &http.Client{Timeout: spec.HTTP_Timeout},
+		tick:    time.NewTicker(time.Duration(float64(time.Second) / spec.Backfill_Rate)),
+		backoff: backoff{min: spec.Backoff_Min, max: spec.Backoff_Max},
+		path:    spec.Archive + ".backfill",
+	}
+	defer b.tick.Stop()
+	if err := b.load(); err != nil {
+		panic(err)
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		if b.Days[key] {
+			log.Printf("%s: already done", key)
+			continue
+		}
+		var hashes map[string]string
+		status, err := b.get(fmt.Sprintf("%s%s.json", spec.History_URL, day.Format("20060102")), &hashes)
+		if status == http.StatusNotFound {
+			log.Printf("%s: no history", key)
+			continue
+		} else if err != nil {
+			panic(fmt.Errorf("%s: %w", key, err))
+		}
+		ids := make([]int, 0, len(hashes))
+		for id := range hashes {
+			killID, err := strconv.Atoi(id)
+			if err != nil {
+				log.Printf("%s: bad killID %q", key, id)
+				continue
+			}
+			ids = append(ids, killID)
+		}
+		sort.Ints(ids)
+		var fetched, skipped int
+		for _, killID := range ids {
+			if r.st.Has(killID) {
+				skipped++
+				continue
+			}
+			hash := hashes[strconv.Itoa(killID)]
+			var km json.RawMessage
+			status, err := b.get(fmt.Sprintf("%skillmails/%d/%s/", spec.ESI_URL, killID, hash), &km)
+			if err != nil {
+				var reason string
+				switch {
+				case errors.Is(err, errUndecodable):
+					reason = "esi undecodable"
+				case status != 0:
+					reason = fmt.Sprintf("esi %d", status)
+				default:
+					panic(fmt.Errorf("%s: killID %d: %w", key, killID, err))
+				}
+				// ESI won't return this one on a retry; keep a record of it.
+				pkg, _ := json.Marshal(map[string]interface{}{"killID": killID, "hash": hash})
+				if err := r.quarantine(pkg, killID, reason); err != nil {
+					panic(err)
+				}
+				continue
+			}
+			pkg, err := json.Marshal(backfillPackage{
+				KillID:   killID,
+				Killmail: km,
+				Zkb:      b.zkb(spec.Zkb_URL, killID, hash),
+			})
+			if err != nil {
+				panic(err)
+			}
+			// write dead-letters rather than failing, so only count the
+			// killmails that reached the sink.
+			before := r.st.Count
+			if err := r.write(context.Background(), pkg); err != nil {
+				panic(err)
+			}
+			if r.st.Count > before {
+				fetched++
+			}
+		}
+		// The day is only marked done once its killmails are in a saved
+		// checkpoint.
+		if err := r.st.Save(); err != nil {
+			panic(err)
+		}
+		b.Days[key] = true
+		if err := writeJSONAtomic(b.path, b); err != nil {
+			panic(err)
+		}
+		log.Printf("%s: %d killmails, %d fetched, %d already written", key, len(ids), fetched, skipped)
+	}
+}
+
+// backfillPackage is a RedisQ package rebuilt from the history, ESI and
+// zKillboard endpoints.
+type backfillPackage struct {
+	KillID   int             `json:"killID"`
+	Killmail json.RawMessage `json:"killmail"`
+	Zkb      json.RawMessage `json:"zkb"`
+}
+
+// zkb fetches the zkb block (fittedValue, labels, locationID...) for killID
+// from url. If url is empty or zKillboard doesn't have it, the block only has
+// the hash, so value and label rules see those killmails as unvalued and
+// unlabeled.
+func (b *backfiller) zkb(url string, killID int, hash string) json.RawMessage {
+	if url != "" {
+		var kills []struct {
+			KillmailID int             `json:"killmail_id"`
+			Zkb        json.RawMessage `json:"zkb"`
+		}
+		_, err := b.get(fmt.Sprintf("%s%d/", url, killID), &kills)
+		if err == nil && len(kills) == 1 && kills[0].KillmailID == killID && len(kills[0].Zkb) > 0 {
+			return kills[0].Zkb
+		}
+		log.Printf("killID %d: no zkb block (%v); writing hash only", killID, err)
+	}
+	zkb, _ := json.Marshal(map[string]string{"hash": hash})
+	return zkb
+}
+
+type backfiller struct {
+	Days map[string]bool
+
+	client  *http.Client
+	tick    *time.Ticker
+	backoff backoff
+	path    string
+}
+
+func (b *backfiller) load() error {
+	data, err := os.ReadFile(b.path)
+	if err == nil {
+		if err := json.Unmarshal(data, b); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if b.Days == nil {
+		b.Days = map[string]bool{}
+	}
+	return nil
+}
+
+// errUndecodable is returned by get for a 200 response whose body isn't the
+// expected JSON. Retrying won't help, so it isn't.
+var errUndecodable = errors.New("undecodable response")
+
+// get fetches url as JSON into v, waiting for the rate limiter before each
+// request. Network errors, rate limiting and server errors are retried with
+// backoff. Any other non-200 status or an undecodable body is returned along
+// with an error.
+func (b *backfiller) get(url string, v interface{}) (status int, err error) {
+	defer b.backoff.Reset()
+	for {
+		<-b.tick.C
+		status, err = b.getOnce(url, v)
+		if err == nil {
+			return status, nil
+		}
+		switch {
+		case status == 0,
+			status == http.StatusTooManyRequests,
+			status == 420, // ESI error limit
+			status >= 500:
+			d := b.backoff.Next()
+			log.Printf("%s: %v; retrying in %s", url, err, d)
+			time.Sleep(d)
+		default:
+			return status, err
+		}
+	}
+}
+
+func (b *backfiller) getOnce(url string, v interface{}) (int, error) {
+	resp, err := b.client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return resp.StatusCode, fmt.Errorf("%w: %v", errUndecodable, err)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testBackfiller(t *testing.T) *backfiller {
+	b := &backfiller{
+		client:  http.DefaultClient,
+		tick:    time.NewTicker(time.Millisecond),
+		backoff: backoff{min: time.Millisecond, max: time.Millisecond},
+	}
+	t.Cleanup(b.tick.Stop)
+	return b
+}
+
+func TestBackfillGetUndecodable(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"killmail_id":`))
+	}))
+	defer srv.Close()
+	var v map[string]interface{}
+	status, err := testBackfiller(t).get(srv.URL, &v)
+	if !errors.Is(err, errUndecodable) || status != 200 {
+		t.Fatalf("got %d, %v; want 200, undecodable", status, err)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want 1", requests)
+	}
+}
+
+func TestBackfillZkb(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1/":
+			w.Write([]byte(`[{"killmail_id":1,"zkb":{"hash":"a","fittedValue":5}}]`))
+		case "/2/":
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	b := testBackfiller(t)
+	tests := []struct {
+		url    string
+		killID int
+		want   string
+	}{
+		{srv.URL + "/", 1, `{"hash":"a","fittedValue":5}`},
+		{srv.URL + "/", 2, `{"hash":"a"}`},
+		{srv.URL + "/", 3, `{"hash":"a"}`},
+		{"", 1, `{"hash":"a"}`},
+	}
+	for _, tc := range tests {
+		if got := string(b.zkb(tc.url, tc.killID, "a")); got != tc.want {
+			t.Errorf("%q %d: got %s, want %s", tc.url, tc.killID, got, tc.want)
+		}
+	}
+}
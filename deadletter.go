@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Reasons a RedisQ package is quarantined instead of archived.
+const (
+	deadUndecodable = "undecodable"
+	deadStructure   = "unexpected structure"
+	deadNoKillID    = "missing killID"
+	deadNoKillmail  = "missing killmail"
+	deadNoVictim    = "missing victim"
+	deadNoZkb       = "missing zkb"
+	deadMismatch    = "killID mismatch"
+	// deadSink is a package the sink kept failing to write.
+	deadSink = "sink"
+)
+
+// checkPackage verifies that pkg has everything ZkillboardKillmail needs. It
+// returns the killID, or the reason the package should be dead-lettered.
+func checkPackage(pkg []byte) (killID int, reason string) {
+	var v struct {
+		KillID   *int `json:"killID"`
+		Killmail *struct {
+			KillmailID *int `json:"killmail_id"`
+			Victim     *struct {
+				ShipTypeID *int `json:"ship_type_id"`
+				Items      []struct {
+					Flag       *int `json:"flag"`
+					ItemTypeID *int `json:"item_type_id"`
+				} `json:"items"`
+			} `json:"victim"`
+		} `json:"killmail"`
+		Zkb *struct {
+			FittedValue *float64 `json:"fittedValue"`
+		} `json:"zkb"`
+	}
+	if err := json.Unmarshal(pkg, &v); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			return 0, deadStructure
+		}
+		return 0, deadUndecodable
+	}
+	switch {
+	case v.KillID == nil || *v.KillID <= 0:
+		return 0, deadNoKillID
+	case v.Killmail == nil:
+		return *v.KillID, deadNoKillmail
+	case v.Killmail.Victim == nil || v.Killmail.Victim.ShipTypeID == nil:
+		return *v.KillID, deadNoVictim
+	case v.Zkb == nil:
+		return *v.KillID, deadNoZkb
+	case v.Killmail.KillmailID != nil && *v.Killmail.KillmailID != *v.KillID:
+		return *v.KillID, deadMismatch
+	}
+	for _, item := range v.Killmail.Victim.Items {
+		if item.Flag == nil || item.ItemTypeID == nil {
+			return *v.KillID, deadStructure
+		}
+	}
+	return *v.KillID, ""
+}
+
+// deadLetter is one line of the dead-letter file.
+type deadLetter struct {
+	Reason   string
+	Received time.Time
+	KillID   int `json:",omitempty"`
+	// Package is set if the package was valid JSON, otherwise Raw is.
+	Package json.RawMessage `json:",omitempty"`
+	Raw     string          `json:",omitempty"`
+}
+
+// quarantine appends pkg to the dead-letter file at path.
+func quarantine(path string, pkg []byte, killID int, reason string) error {
+	dl := deadLetter{
+		Reason:   reason,
+		Received: time.Now().UTC(),
+		KillID:   killID,
+	}
+	if json.Valid(pkg) {
+		dl.Package = pkg
+	} else {
+		dl.Raw = string(pkg)
+	}
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	if _, err := appendLine(path, b); err != nil {
+		return fmt.Errorf("dead letter: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckPackage(t *testing.T) {
+	tests := []struct {
+		name   string
+		pkg    string
+		killID int
+		reason string
+	}{
+		{
+			name:   "ok",
+			pkg:    `{"killID":1,"killmail":{"killmail_id":1,"victim":{"ship_type_id":587,"items":[{"flag":11,"item_type_id":484}]}},"zkb":{}}`,
+			killID: 1,
+		},
+		{
+			name:   "no killmail_id",
+			pkg:    `{"killID":1,"killmail":{"victim":{"ship_type_id":587}},"zkb":{}}`,
+			killID: 1,
+		},
+		{
+			name:   "undecodable",
+			pkg:    `{"killID":`,
+			reason: deadUndecodable,
+		},
+		{
+			name:   "wrong type",
+			pkg:    `{"killID":"1"}`,
+			reason: deadStructure,
+		},
+		{
+			name:   "no killID",
+			pkg:    `{"killmail":{},"zkb":{}}`,
+			reason: deadNoKillID,
+		},
+		{
+			name:   "zero killID",
+			pkg:    `{"killID":0}`,
+			reason: deadNoKillID,
+		},
+		{
+			name:   "no killmail",
+			pkg:    `{"killID":1,"zkb":{}}`,
+			killID: 1,
+			reason: deadNoKillmail,
+		},
+		{
+			name:   "no victim",
+			pkg:    `{"killID":1,"killmail":{},"zkb":{}}`,
+			killID: 1,
+			reason: deadNoVictim,
+		},
+		{
+			name:   "no victim ship",
+			pkg:    `{"killID":1,"killmail":{"victim":{}},"zkb":{}}`,
+			killID: 1,
+			reason: deadNoVictim,
+		},
+		{
+			name:   "no zkb",
+			pkg:    `{"killID":1,"killmail":{"victim":{"ship_type_id":587}}}`,
+			killID: 1,
+			reason: deadNoZkb,
+		},
+		{
+			name:   "mismatch",
+			pkg:    `{"killID":1,"killmail":{"killmail_id":2,"victim":{"ship_type_id":587}},"zkb":{}}`,
+			killID: 1,
+			reason: deadMismatch,
+		},
+		{
+			name:   "item without flag",
+			pkg:    `{"killID":1,"killmail":{"victim":{"ship_type_id":587,"items":[{"item_type_id":484}]}},"zkb":{}}`,
+			killID: 1,
+			reason: deadStructure,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			killID, reason := checkPackage([]byte(tc.pkg))
+			if killID != tc.killID || reason != tc.reason {
+				t.Errorf("got %d %q, want %d %q", killID, reason, tc.killID, tc.reason)
+			}
+		})
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead.json")
+	if err := quarantine(path, []byte(`{"killID":1}`), 1, deadNoKillmail); err != nil {
+		t.Fatal(err)
+	}
+	if err := quarantine(path, []byte("<html>"), 0, deadUndecodable); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []deadLetter
+	dec := json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var dl deadLetter
+		if err := dec.Decode(&dl); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, dl)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d dead letters", len(got))
+	}
+	if got[0].Reason != deadNoKillmail || got[0].KillID != 1 || string(got[0].Package) != `{"killID":1}` || got[0].Raw != "" {
+		t.Errorf("valid JSON: %+v", got[0])
+	}
+	if got[1].Reason != deadUndecodable || got[1].Package != nil || got[1].Raw != "<html>" {
+		t.Errorf("invalid JSON: %+v", got[1])
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+// SlotLayout is how many slots a fit has in each rack.
+type SlotLayout struct {
+	Hi, Med, Lo, Rig, Sub, Service int
+}
+
+// SlotLayout returns the slots of d's ship, including the ones its subsystems
+// add.
+func (s *SDEData) SlotLayout(d *DBKillmail) SlotLayout {
+	ship := s.Items[d.Ship].Attributes
+	l := SlotLayout{
+		Hi:      int(ship["hiSlots"]),
+		Med:     int(ship["medSlots"]),
+		Lo:      int(ship["lowSlots"]),
+		Rig:     int(ship["rigSlots"]),
+		Sub:     int(ship["maxSubSystems"]),
+		Service: int(ship["serviceSlots"]),
+	}
+	for _, sub := range d.Sub {
+		attrs := s.Items[sub.ID].Attributes
+		l.Hi += int(attrs["hiSlotModifier"])
+		l.Med += int(attrs["medSlotModifier"])
+		l.Lo += int(attrs["lowSlotModifier"])
+	}
+	for _, n := range []*int{&l.Hi, &l.Med, &l.Lo, &l.Rig, &l.Sub, &l.Service} {
+		if *n > 8 {
+			*n = 8
+		}
+	}
+	return l
+}
+
+// Resource is how much of a fitting resource a fit uses and how much its ship
+// has.
+type Resource struct {
+	Used   float64
+	Output float64
+}
+
+func (r Resource) Over() bool {
+	// Allow for rounding in the SDE's values.
+	return r.Used > r.Output+1e-6
+}
+
+type Fitting struct {
+	CPU         Resource
+	Power       Resource
+	Calibration Resource
+}
+
+func (f Fitting) Over() bool {
+	return f.CPU.Over() || f.Power.Over() || f.Calibration.Over()
+}
+
+// FittingStats are a fit's fitting resources with no skills trained and with
+// the fitting skills at V.
+type FittingStats struct {
+	Base Fitting
+	AllV Fitting
+	// Overfit is whether the fit needs more than its ship has even with all
+	// skills at V, which takes implants, officer modules or bad data.
+	Overfit bool
+}
+
+// Fitting computes d's fitting resources. It returns false if the ship has no
+// fitting attributes, like with an SDE extract from before they were kept.
+//
+// Besides hull bonuses and the modules and rigs that change the ship's
+// output, the all V values only include the skills that apply to every fit:
+// CPU Management and Power Grid Management (+5% output per level), Weapon
+// Upgrades (-5% weapon CPU per level) and Advanced Weapon Upgrades (-2% weapon
+// powergrid per level). No skill changes calibration.
+func (s *SDEData) Fitting(d *DBKillmail) (FittingStats, bool) {
+	if _, ok := s.Items[d.Ship].Attributes["cpuOutput"]; !ok {
+		return FittingStats{}, false
+	}
+	allV := s.fitting(d, 5)
+	return FittingStats{
+		Base:    s.fitting(d, 0),
+		AllV:    allV,
+		Overfit: allV.Over(),
+	}, true
+}
+
+// fitting computes d's fitting resources with all skills at level.
+func (s *SDEData) fitting(d *DBKillmail, level int) Fitting {
+	b := s.hullBonuses(d.Ship, level)
+	ship := b.Ship()
+	f := Fitting{
+		CPU:         Resource{Output: ship["cpuOutput"]},
+		Power:       Resource{Output: ship["powerOutput"]},
+		Calibration: Resource{Output: ship["upgradeCapacity"]},
+	}
+	skill := float64(level)
+	cpuMult, powerMult := 1+0.05*skill, 1+0.05*skill
+	var cpuAdd, powerAdd float64
+	for _, rack := range [][8]DBItem{d.Hi, d.Med, d.Lo, d.Sub, d.Service} {
+		for _, ic := range rack {
+			item, ok := s.Items[ic.ID]
+			if !ok {
+				continue
+			}
+			attrs := b.Module(ic.ID)
+			cpu, power := attrs["cpu"], attrs["power"]
+			if item.HasEffect("turretFitted") || item.HasEffect("launcherFitted") {
+				cpu *= 1 - 0.05*skill
+				power *= 1 - 0.02*skill
+			}
+			f.CPU.Used += cpu
+			f.Power.Used += power
+
+			// Subsystems add to the hull's output.
+			powerAdd += attrs["powerOutput"]
+			cpuAdd += attrs["cpuOutput"]
+
+			if m, ok := attrs["cpuMultiplier"]; ok {
+				cpuMult *= m
+			}
+			if m, ok := attrs["powerOutputMultiplier"]; ok {
+				powerMult *= m
+			}
+			powerAdd += attrs["powerIncrease"]
+		}
+	}
+	for _, ic := range d.Rig {
+		if _, ok := s.Items[ic.ID]; !ok {
+			continue
+		}
+		attrs := b.Module(ic.ID)
+		f.Calibration.Used += attrs["upgradeCost"]
+		// Ancillary current routers and processor overclocking units.
+		powerMult *= 1 + attrs["powerEngineeringOutputBonus"]/100
+		cpuMult *= 1 + attrs["cpuOutputBonus2"]/100
+	}
+	// Dogma adds before it multiplies.
+	f.CPU.Output = (f.CPU.Output + cpuAdd) * cpuMult
+	f.Power.Output = (f.Power.Output + powerAdd) * powerMult
+	return f
+}
+
+// bonuses applies a ship's hull bonuses to the attributes of the ship and of
+// the modules and charges fitted to it.
+type bonuses struct {
+	s     *SDEData
+	ship  Item
+	level float64
+	cache map[bonusKey]map[string]float64
+}
+
+type bonusKey struct {
+	target string
+	id     int
+}
+
+// hullBonuses returns the bonuses of ship with its skills at level.
+func (s *SDEData) hullBonuses(ship, level int) *bonuses {
+	return &bonuses{
+		s:     s,
+		ship:  s.Items[ship],
+		level: float64(level),
+		cache: map[bonusKey]map[string]float64{},
+	}
+}
+
+func (b *bonuses) Ship() map[string]float64 {
+	return b.apply("ship", b.ship.ID)
+}
+
+func (b *bonuses) Module(id int) map[string]float64 {
+	return b.apply("module", id)
+}
+
+func (b *bonuses) Charge(id int) map[string]float64 {
+	return b.apply("charge", id)
+}
+
+// apply returns the attributes of item id with the modifiers for target
+// applied. Attributes the item doesn't have aren't added. The returned map must
+// not be modified.
+func (b *bonuses) apply(target string, id int) map[string]float64 {
+	key := bonusKey{target, id}
+	if attrs, ok := b.cache[key]; ok {
+		return attrs
+	}
+	item := b.s.Items[id]
+	attrs := item.Attributes
+	var mods []Modifier
+	for _, m := range b.ship.Modifiers {
+		if m.Target != target ||
+			(m.Skill != 0 && !item.RequiresSkill(m.Skill)) ||
+			(m.Group != 0 && m.Group != item.Group) {
+			continue
+		}
+		if _, ok := attrs[m.Attribute]; ok {
+			mods = append(mods, m)
+		}
+	}
+	if len(mods) > 0 {
+		attrs = applyModifiers(attrs, mods, b.level)
+	}
+	b.cache[key] = attrs
+	return attrs
+}
+
+// applyModifiers returns a copy of attrs with mods applied in dogma's order:
+// pre-multiply, pre-divide, add, subtract, post-multiply, post-divide and
+// post-percent. Hull bonuses aren't stacking penalized. PerLevel additions and
+// percentages are multiplied by level.
+func applyModifiers(attrs map[string]float64, mods []Modifier, level float64) map[string]float64 {
+	ret := make(map[string]float64, len(attrs))
+	for k, v := range attrs {
+		ret[k] = v
+	}
+	for op := 0; op <= 6; op++ {
+		for _, m := range mods {
+			if m.Operation != op {
+				continue
+			}
+			v := m.Value
+			if m.PerLevel && (op == 2 || op == 3 || op == 6) {
+				v *= level
+			}
+			switch op {
+			case 0, 4:
+				ret[m.Attribute] *= v
+			case 1, 5:
+				if v != 0 {
+					ret[m.Attribute] /= v
+				}
+			case 2:
+				ret[m.Attribute] += v
+			case 3:
+				ret[m.Attribute] -= v
+			case 6:
+				ret[m.Attribute] *= 1 + v/100
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// testSDE is a frigate with a few modules, rigs and hull bonuses.
+func testSDE() *SDEData {
+	const gunnery = 3300
+	return &SDEData{
+		Items: map[int]Item{
+			1: {ID: 1, Group: 25, Attributes: map[string]float64{
+				"cpuOutput": 100, "powerOutput": 50, "upgradeCapacity": 400,
+			}},
+			// The same hull with a role bonus to turret CPU and a per level bonus
+			// to powergrid.
+			2: {ID: 2, Group: 25, Attributes: map[string]float64{
+				"cpuOutput": 100, "powerOutput": 50, "upgradeCapacity": 400,
+			}, Modifiers: []Modifier{
+				{Target: "module", Skill: gunnery, Attribute: "cpu", Operation: 6, Value: -50},
+				{Target: "ship", Attribute: "powerOutput", Operation: 6, Value: 5, PerLevel: true},
+			}},
+			10: {ID: 10, Group: 55, Effects: []string{"turretFitted"}, Attributes: map[string]float64{
+				"cpu": 20, "power": 10, "requiredSkill1": gunnery,
+			}},
+			11: {ID: 11, Group: 40, Attributes: map[string]float64{"cpu": 30, "power": 30}},
+			20: {ID: 20, Group: 781, Attributes: map[string]float64{"upgradeCost": 100, "powerEngineeringOutputBonus": 10}},
+			21: {ID: 21, Group: 781, Attributes: map[string]float64{"upgradeCost": 150, "cpuOutputBonus2": 10}},
+		},
+	}
+}
+
+func testFit(ship int, hi []int, med []int, rig []int) *DBKillmail {
+	d := &DBKillmail{Ship: ship}
+	for i, id := range hi {
+		d.Hi[i].ID = id
+	}
+	for i, id := range med {
+		d.Med[i].ID = id
+	}
+	for i, id := range rig {
+		d.Rig[i].ID = id
+	}
+	return d
+}
+
+func TestFitting(t *testing.T) {
+	s := testSDE()
+	turrets := []int{10, 10, 10, 10}
+	tests := []struct {
+		name    string
+		fit     *DBKillmail
+		base    Fitting
+		allV    Fitting
+		overfit bool
+	}{
+		{
+			name: "weapon skills",
+			fit:  testFit(1, turrets, []int{11}, nil),
+			base: Fitting{
+				CPU:         Resource{Used: 110, Output: 100},
+				Power:       Resource{Used: 70, Output: 50},
+				Calibration: Resource{Output: 400},
+			},
+			allV: Fitting{
+				CPU:         Resource{Used: 90, Output: 125},
+				Power:       Resource{Used: 66, Output: 62.5},
+				Calibration: Resource{Output: 400},
+			},
+			overfit: true,
+		},
+		{
+			name: "rigs",
+			fit:  testFit(1, turrets, []int{11}, []int{20, 20, 21}),
+			base: Fitting{
+				CPU:         Resource{Used: 110, Output: 110},
+				Power:       Resource{Used: 70, Output: 60.5},
+				Calibration: Resource{Used: 350, Output: 400},
+			},
+			allV: Fitting{
+				CPU:         Resource{Used: 90, Output: 137.5},
+				Power:       Resource{Used: 66, Output: 75.625},
+				Calibration: Resource{Used: 350, Output: 400},
+			},
+		},
+		{
+			name: "hull bonuses",
+			fit:  testFit(2, turrets, []int{11}, nil),
+			base: Fitting{
+				CPU:         Resource{Used: 70, Output: 100},
+				Power:       Resource{Used: 70, Output: 50},
+				Calibration: Resource{Output: 400},
+			},
+			allV: Fitting{
+				CPU:         Resource{Used: 60, Output: 125},
+				Power:       Resource{Used: 66, Output: 78.125},
+				Calibration: Resource{Output: 400},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := s.Fitting(tc.fit)
+			if !ok {
+				t.Fatal("no fitting")
+			}
+			if !fittingEqual(got.Base, tc.base) {
+				t.Errorf("base %+v, want %+v", got.Base, tc.base)
+			}
+			if !fittingEqual(got.AllV, tc.allV) {
+				t.Errorf("all V %+v, want %+v", got.AllV, tc.allV)
+			}
+			if got.Overfit != tc.overfit {
+				t.Errorf("overfit %v, want %v", got.Overfit, tc.overfit)
+			}
+		})
+	}
+	if _, ok := s.Fitting(&DBKillmail{Ship: 99}); ok {
+		t.Error("fitting for a ship without attributes")
+	}
+}
+
+func fittingEqual(a, b Fitting) bool {
+	for _, r := range [][2]Resource{{a.CPU, b.CPU}, {a.Power, b.Power}, {a.Calibration, b.Calibration}} {
+		if !closeTo(r[0].Used, r[1].Used) || !closeTo(r[0].Output, r[1].Output) {
+			return false
+		}
+	}
+	return true
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestApplyModifiers(t *testing.T) {
+	attrs := map[string]float64{"a": 10, "b": 2}
+	got := applyModifiers(attrs, []Modifier{
+		// Listed out of order; post-percent applies after the addition.
+		{Attribute: "a", Operation: 6, Value: 10, PerLevel: true},
+		{Attribute: "a", Operation: 2, Value: 5},
+		{Attribute: "b", Operation: 0, Value: 3, PerLevel: true},
+		{Attribute: "b", Operation: 5, Value: 2},
+	}, 5)
+	if !closeTo(got["a"], 22.5) || !closeTo(got["b"], 3) {
+		t.Errorf("got %v", got)
+	}
+	if attrs["a"] != 10 {
+		t.Error("attrs modified")
+	}
+}
@@ -9,6 +9,7 @@ import {
 } from 'react-router-dom';
 import GAListener from './tracker';
 import Search from './Search';
+import Browse from './Browse';
 import About from './About';
 import Fit from './Fit';
 import Fits from './Fits';
@@ -32,6 +33,9 @@ export default function App() {
 							<li className="ma2">
 								<NavLink to="/search">search</NavLink>
 							</li>
+							<li className="ma2">
+								<NavLink to="/browse">browse</NavLink>
+							</li>
 							<li className="ma2">
 								<NavLink to="/saved">saved</NavLink>
 							</li>
@@ -45,6 +49,7 @@ export default function App() {
 							<Switch>
 								<Route path="/fit/:id" children={<Fit />} />
 								<Route path="/search" children={<Search />} />
+								<Route path="/browse" children={<Browse />} />
 								<Route path="/about" children={<About />} />
 								<Route path="/saved" children={<Saved />} />
 								<Route path="/" children={<Fits />} />
@@ -0,0 +1,69 @@
+import React, { useState, useEffect } from 'react';
+import { Link, useLocation } from 'react-router-dom';
+import { Icon, setTitle, Fetch, flexChildrenClass } from './common';
+
+interface MarketGroup {
+	ID: number;
+	Name: string;
+	Parent?: number;
+}
+
+interface BrowseResults {
+	Group?: MarketGroup;
+	Path: MarketGroup[] | null;
+	Groups: (MarketGroup & { ItemFits: number })[] | null;
+	Items: { Type: string; Name: string; ID: number; Fits: number }[] | null;
+}
+
+export default function Browse() {
+	const search = new URLSearchParams(window.location.search);
+	const marketGroup = search.get('marketGroup') || '';
+
+	const [data, setData] = useState<BrowseResults | null>(null);
+	const location = useLocation();
+
+	useEffect(() => {
+		Fetch<BrowseResults>(
+			'Browse?marketGroup=' + encodeURIComponent(marketGroup),
+			res => {
+				setTitle(res.Group ? res.Group.Name : 'browse');
+				setData(res);
+			}
+		);
+	}, [location, marketGroup]);
+
+	if (!data) {
+		return null;
+	}
+	return (
+		<div className="flex flex-column">
+			<div className={flexChildrenClass}>
+				<Link to="/browse">browse</Link>
+				{(data.Path || []).map(g => (
+					<span key={g.ID}>
+						{' > '}
+						<Link to={'/browse?marketGroup=' + g.ID.toString()}>{g.Name}</Link>
+					</span>
+				))}
+				{data.Group ? ' > ' + data.Group.Name : null}
+			</div>
+			<div className={flexChildrenClass}>
+				{(data.Groups || []).map(g => (
+					<div key={g.ID} className="ma2">
+						<Link to={'/browse?marketGroup=' + g.ID.toString()}>{g.Name}</Link>{' '}
+						({g.ItemFits})
+					</div>
+				))}
+				{(data.Items || []).map(v => (
+					<div key={v.ID} className="ma2">
+						<Link to={'/?' + v.Type + '=' + v.ID.toString()}>
+							<Icon id={v.ID} alt={v.Name} />
+							{v.Name}
+						</Link>{' '}
+						({v.Fits})
+					</div>
+				))}
+			</div>
+		</div>
+	);
+}
@@ -4,7 +4,9 @@ import {
 	Ref,
 	ISK,
 	Icon,
+	ItemAmmo,
 	ItemCharge,
+	ItemQuantity,
 	setTitle,
 	Fetch,
 	flexChildrenClass,
@@ -66,6 +68,60 @@ export default function Fit() {
 						Fitted value: <ISK isk={data.Cost} />
 					</div>
 				) : null}
+				{data.TotalValue ? (
+					<div>
+						Dropped: <ISK isk={data.DroppedValue || 0} /> of{' '}
+						<ISK isk={data.TotalValue} />
+					</div>
+				) : null}
+				{data.Stats ? (
+					<div>
+						DPS: {data.Stats.DPS.toFixed(0)}, EHP:{' '}
+						{data.Stats.EHP.uniform.toFixed(0)}, speed:{' '}
+						{data.Stats.Speed.toFixed(0)} m/s, align:{' '}
+						{data.Stats.Align.toFixed(1)}s (all V estimates)
+					</div>
+				) : null}
+				{data.Fitting ? (
+					<div>
+						{(['CPU', 'Power', 'Calibration'] as const).map((k) => {
+							const base = data.Fitting!.Base[k];
+							const allV = data.Fitting!.AllV[k];
+							return (
+								<div key={k}>
+									{k}: {allV.Used.toFixed(1)} / {allV.Output.toFixed(1)} (no
+									skills: {base.Used.toFixed(1)} / {base.Output.toFixed(1)})
+								</div>
+							);
+						})}
+						{data.Fitting.Overfit ? <div>Overfit even with all V</div> : null}
+					</div>
+				) : null}
+				{data.Time ? <div>Killed: {data.Time}</div> : null}
+				{data.Labels ? <div>Labels: {data.Labels.join(', ')}</div> : null}
+				{data.Victim ? (
+					<div className="list">
+						Victim:
+						{(['Character', 'Corporation', 'Alliance'] as const).map((k) => {
+							const v = data.Victim[k];
+							return v ? (
+								<Link key={k} to={'/?' + k.toLowerCase() + '=' + v.ID}>
+									{v.Name || v.ID}
+								</Link>
+							) : null;
+						})}
+					</div>
+				) : null}
+				{data.System ? (
+					<div>
+						Location:{' '}
+						<Link to={'/?system=' + data.System.ID}>{data.System.Name}</Link> (
+						{data.System.Security.toFixed(1)}),{' '}
+						<Link to={'/?region=' + data.System.Region.ID}>
+							{data.System.Region.Name}
+						</Link>
+					</div>
+				) : null}
 				<div className="list">
 					<a href={'https://zkillboard.com/kill/' + data.ID + '/'}>
 						zkillboard
@@ -76,50 +132,117 @@ export default function Fit() {
 			<div className={flexChildrenClass}>
 				<div>
 					<h3>high slots</h3>
-					<Slots items={data.Hi} />
+					<Slots items={data.Hi} slots={data.Slots.Hi} />
 				</div>
 				<div>
 					<h3>medium slots</h3>
-					<Slots items={data.Med} />
+					<Slots items={data.Med} slots={data.Slots.Med} />
 				</div>
 				<div>
 					<h3>low slots</h3>
-					<Slots items={data.Lo} />
+					<Slots items={data.Lo} slots={data.Slots.Lo} />
 				</div>
 				<div>
 					<h3>rigs</h3>
-					<Slots items={data.Rig} />
+					<Slots items={data.Rig} slots={data.Slots.Rig} />
 				</div>
 				{data.Sub[0] ? (
 					<div>
 						<h3>subsystems</h3>
-						<Slots items={data.Sub} />
+						<Slots items={data.Sub} slots={data.Slots.Sub} />
+					</div>
+				) : null}
+				{data.Service.some((v) => v.Name) ? (
+					<div>
+						<h3>service slots</h3>
+						<Slots items={data.Service} slots={data.Slots.Service} />
 					</div>
 				) : null}
 				<div>
 					<h3>charges</h3>
-					<Slots items={data.Charge} />
+					{data.Ammo ? (
+						<Ammo items={data.Ammo} />
+					) : (
+						<Slots items={data.Charge} />
+					)}
 				</div>
+				{data.Drone ? (
+					<div>
+						<h3>drones</h3>
+						<Quantities items={data.Drone} />
+					</div>
+				) : null}
+				{data.Fighter ? (
+					<div>
+						<h3>fighters</h3>
+						<Quantities items={data.Fighter} />
+					</div>
+				) : null}
+				{data.Cargo ? (
+					<div>
+						<h3>cargo</h3>
+						<Quantities items={data.Cargo} />
+					</div>
+				) : null}
+				{data.Implant ? (
+					<div>
+						<h3>implants</h3>
+						<Slots items={data.Implant} />
+					</div>
+				) : null}
+				{data.Booster ? (
+					<div>
+						<h3>boosters</h3>
+						<Slots items={data.Booster} />
+					</div>
+				) : null}
 			</div>
 		</div>
 	);
 }
 
-function Slots(props: { items: ItemCharge[] }) {
+function Slots(props: { items: ItemCharge[]; slots?: number }) {
 	if (!props.items) {
 		return null;
 	}
+	const items = props.items.filter((v) => v.Name);
+	const empty = Math.max((props.slots || 0) - items.length, 0);
+	return (
+		<Fragment>
+			{items.map((v, idx) => {
+				return (
+					<div key={idx}>
+						<Link to={'/?item=' + v.ID} title={mutatedFrom(v)}>
+							<Icon id={v.ID} alt={v.Name} />
+							{v.Name}
+						</Link>
+						{v.Dropped ? ' (dropped)' : null}
+					</div>
+				);
+			})}
+			{Array.from({ length: empty }, (_, idx) => (
+				<div key={'empty' + idx} style={{ color: 'var(--emph-medium)' }}>
+					[empty]
+				</div>
+			))}
+		</Fragment>
+	);
+}
+
+function Ammo(props: { items: ItemAmmo[] }) {
 	return (
 		<Fragment>
 			{props.items
 				.filter((v) => v.Name)
-				.map((v, idx) => {
+				.map((v) => {
 					return (
-						<div key={idx}>
+						<div key={v.ID}>
 							<Link to={'/?item=' + v.ID}>
 								<Icon id={v.ID} alt={v.Name} />
+								{v.Loaded ? v.Loaded + 'x ' : null}
 								{v.Name}
 							</Link>
+							{v.Cargo ? ' (+' + v.Cargo + ' in cargo)' : null}
 						</div>
 					);
 				})}
@@ -127,9 +250,46 @@ function Slots(props: { items: ItemCharge[] }) {
 	);
 }
 
+function mutatedFrom(item: ItemCharge) {
+	if (!item.Mutation) {
+		return undefined;
+	}
+	return (
+		'mutated from one of: ' +
+		item.Mutation.Sources.map((v) => v.Name).join(', ')
+	);
+}
+
+function Quantities(props: { items: ItemQuantity[] }) {
+	return (
+		<Fragment>
+			{props.items.map((v) => {
+				if (!v.Name) {
+					// Not in the SDE data, so it can't be searched for.
+					return (
+						<div key={v.ID}>
+							<Icon id={v.ID} alt={'type ' + v.ID} />
+							{v.Quantity}x type {v.ID}
+						</div>
+					);
+				}
+				return (
+					<div key={v.ID}>
+						<Link to={'/?item=' + v.ID}>
+							<Icon id={v.ID} alt={v.Name} />
+							{v.Quantity}x {v.Name}
+						</Link>
+					</div>
+				);
+			})}
+		</Fragment>
+	);
+}
+
 function TextFit(data: FitData) {
 	const fit = ['[' + data.Ship.Name + ']'];
-	[data.Lo, data.Med, data.Hi, data.Rig, data.Sub].forEach((slot, idx) => {
+	const racks = [data.Lo, data.Med, data.Hi, data.Rig, data.Sub, data.Service];
+	racks.forEach((slot, idx) => {
 		if (idx > 0) {
 			fit.push('');
 		}
@@ -144,5 +304,16 @@ function TextFit(data: FitData) {
 			fit.push(n);
 		});
 	});
+	[data.Drone, data.Fighter, data.Cargo].forEach((bay) => {
+		if (!bay) {
+			return;
+		}
+		fit.push('');
+		bay.forEach((v) => {
+			if (v.Name) {
+				fit.push(v.Name + ' x' + v.Quantity);
+			}
+		});
+	});
 	return fit.join('\n');
 }
@@ -8,7 +8,9 @@ import {
 	setTitle,
 	Fetch,
 	flexChildrenClass,
+	ItemAmmo,
 	ItemCharge,
+	ItemQuantity,
 } from './common';
 
 function addParam(search: URLSearchParams, name: string, val: string) {
@@ -69,24 +71,6 @@ export default function Fits() {
 						items.map((item) => (
 							<div key={item.ID} className="ma1">
 								filter by {type}: {item.Name}
-								{item.Group ? (
-									<Link
-										to={makeURL(
-											addParam(
-												removeParam(
-													window.location.search,
-													type,
-													item.ID.toString()
-												),
-												'group',
-												item.Group.toString()
-											)
-										)}
-										className="ma1"
-									>
-										(group)
-									</Link>
-								) : null}
 								<button
 									className="mh2 ba b--secondary bg-dp08 pointer"
 									onClick={() =>
@@ -99,7 +83,9 @@ export default function Fits() {
 								>
 									x
 								</button>
-								<Ref ID={item.ID} />
+								{type === 'ship' || type === 'item' ? (
+									<Ref ID={item.ID} />
+								) : null}
 							</div>
 						))
 					)}
@@ -226,10 +212,7 @@ function SlotSummary(props: { items: ItemCharge[] }) {
 }
 
 interface FitsData {
-	Filter: {
-		item: ItemCharge[];
-		ship: ItemCharge[];
-	};
+	Filter: Record<string, NamedData[]>;
 	Fits: FitData[];
 }
 
@@ -237,12 +220,83 @@ export interface FitData {
 	ID: number;
 	Ship: ItemCharge;
 	Cost: number;
+	DestroyedValue?: number;
+	DroppedValue?: number;
+	TotalValue?: number;
+	Time?: string;
+	System?: SystemData;
+	Location?: number;
+	Labels?: string[];
+	Victim: PilotData;
 	Hi: ItemCharge[];
 	Med: ItemCharge[];
 	Lo: ItemCharge[];
 	Rig: ItemCharge[];
 	Sub: ItemCharge[];
-	Charges: ItemCharge[];
+	Service: ItemCharge[];
+	Slots: SlotLayout;
+	Overfit?: boolean;
+	Fitting?: FittingStats;
+	Stats?: FitStats;
+	Charge: ItemCharge[];
+	Drone?: ItemQuantity[];
+	Fighter?: ItemQuantity[];
+	Cargo?: ItemQuantity[];
+	Ammo?: ItemAmmo[];
+	Implant?: ItemCharge[];
+	Booster?: ItemCharge[];
+}
+
+interface NamedData {
+	ID: number;
+	Name?: string;
+}
+
+interface Resource {
+	Used: number;
+	Output: number;
+}
+
+interface Fitting {
+	CPU: Resource;
+	Power: Resource;
+	Calibration: Resource;
+}
+
+export interface FittingStats {
+	Base: Fitting;
+	AllV: Fitting;
+	Overfit: boolean;
+}
+
+export interface FitStats {
+	DPS: number;
+	EHP: { [profile: string]: number };
+	Speed: number;
+	Align: number;
+}
+
+export interface SlotLayout {
+	Hi: number;
+	Med: number;
+	Lo: number;
+	Rig: number;
+	Sub: number;
+	Service: number;
+}
+
+export interface PilotData {
+	Character?: NamedData;
+	Corporation?: NamedData;
+	Alliance?: NamedData;
+}
+
+export interface SystemData {
+	ID: number;
+	Name: string;
+	Security: number;
+	Constellation: { ID: number; Name: string };
+	Region: { ID: number; Name: string };
 }
 
 export function makeFitSummary(fit: FitData): FitSummary {
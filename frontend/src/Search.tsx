@@ -51,8 +51,7 @@ export default function Search() {
 					if (b.Type === 'group') {
 						return 1;
 					}
-					debugger; // should be unreachable
-					return 0;
+					return a.Type.localeCompare(b.Type);
 				});
 			}
 			setData({ search: q, results: res });
@@ -88,7 +87,9 @@ export default function Search() {
 					{data.results.Results.map(v => (
 						<div key={v.ID} className="ma2">
 							<Link to={'/?' + v.Type + '=' + v.ID.toString()}>
-								{v.Type !== 'group' ? <Icon id={v.ID} alt={v.Name} /> : null}
+								{v.Type === 'ship' || v.Type === 'item' ? (
+									<Icon id={v.ID} alt={v.Name} />
+								) : null}
 								{v.Name}
 							</Link>{' '}
 							({v.Type})
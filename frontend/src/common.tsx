@@ -121,7 +121,25 @@ export interface ItemCharge {
 		ID: number;
 		Name: string;
 	};
-	Group?: number;
+	Mutation?: {
+		Mutaplasmids: { ID: number; Name: string }[];
+		Sources: { ID: number; Name: string }[];
+	};
+	Dropped?: boolean;
+	ChargeQty?: number;
+}
+
+export interface ItemQuantity {
+	ID: number;
+	Name: string;
+	Quantity: number;
+}
+
+export interface ItemAmmo {
+	ID: number;
+	Name: string;
+	Loaded: number;
+	Cargo: number;
 }
 
 export const savedPrefix = 'saved-';
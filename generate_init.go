@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -17,6 +18,11 @@ import (
 
 func main() {
 	groups := map[int32]bool{}
+	groupCategories := map[int32]int32{}
+	typeGroups := map[int32]int32{}
+	typeNames := map[int32]string{}
+	// Market groups of the items in items.json.
+	itemMarketGroups := map[int32]int32{}
 	{
 		fmt.Println("reading groupIDs.yaml")
 		r, err := os.Open("sde/fsd/groupIDs.yaml")
@@ -60,6 +66,16 @@ func main() {
 				cat = "ship"
 			case 32:
 				cat = "subsystem"
+			case 18:
+				cat = "drone"
+			case 87:
+				cat = "fighter"
+			case 20:
+				cat = "implant"
+			case 65:
+				cat = "structure"
+			case 66:
+				cat = "structure module"
 			default:
 				continue
 			}
@@ -67,6 +83,7 @@ func main() {
 			sep = ","
 			fmt.Fprintf(f, "\n\t\t(%d, '%s', '%s')", id, m.Name["en"], cat)
 			groups[id] = true
+			groupCategories[id] = m.CategoryID
 			asJson[id] = Group{
 				Name:     m.Name["en"],
 				Lower:    strings.ToLower(m.Name["en"]),
@@ -92,6 +109,144 @@ func main() {
 		}
 	}
 
+	// Dogma attributes and effects of each item, by name, and the hull bonuses
+	// of each type that has any.
+	attributes := map[int32]map[string]float64{}
+	effects := map[int32][]string{}
+	modifiers := map[int32][]Modifier{}
+	{
+		fmt.Println("reading dogmaAttributes.yaml")
+		r, err := os.Open("sde/fsd/dogmaAttributes.yaml")
+		if err != nil {
+			panic(err)
+		}
+		var attrYml map[int32]struct {
+			Name string `yaml:"name"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&attrYml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		names := map[int32]string{}
+		allNames := map[int32]string{}
+		for id, a := range attrYml {
+			allNames[id] = a.Name
+			if keepAttributes[a.Name] {
+				names[id] = a.Name
+			}
+		}
+
+		fmt.Println("reading dogmaEffects.yaml")
+		r, err = os.Open("sde/fsd/dogmaEffects.yaml")
+		if err != nil {
+			panic(err)
+		}
+		var effectYml map[int32]struct {
+			EffectName   string `yaml:"effectName"`
+			ModifierInfo []struct {
+				Domain               string `yaml:"domain"`
+				Func                 string `yaml:"func"`
+				ModifiedAttributeID  int32  `yaml:"modifiedAttributeID"`
+				ModifyingAttributeID int32  `yaml:"modifyingAttributeID"`
+				Operation            int    `yaml:"operation"`
+				SkillTypeID          int32  `yaml:"skillTypeID"`
+				GroupID              int32  `yaml:"groupID"`
+			} `yaml:"modifierInfo"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&effectYml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		effectNames := map[int32]string{}
+		for id, e := range effectYml {
+			if keepEffects[e.EffectName] {
+				effectNames[id] = e.EffectName
+			}
+		}
+
+		fmt.Println("reading typeDogma.yaml")
+		r, err = os.Open("sde/fsd/typeDogma.yaml")
+		if err != nil {
+			panic(err)
+		}
+		var yml map[int32]struct {
+			DogmaAttributes []struct {
+				AttributeID int32   `yaml:"attributeID"`
+				Value       float64 `yaml:"value"`
+			} `yaml:"dogmaAttributes"`
+			DogmaEffects []struct {
+				EffectID int32 `yaml:"effectID"`
+			} `yaml:"dogmaEffects"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		for id, t := range yml {
+			for _, a := range t.DogmaAttributes {
+				name, ok := names[a.AttributeID]
+				if !ok {
+					continue
+				}
+				if attributes[id] == nil {
+					attributes[id] = map[string]float64{}
+				}
+				attributes[id][name] = a.Value
+			}
+			for _, e := range t.DogmaEffects {
+				if name, ok := effectNames[e.EffectID]; ok {
+					effects[id] = append(effects[id], name)
+				}
+			}
+			sort.Strings(effects[id])
+
+			// Hull bonuses are the modifiers of the type's effects that change a
+			// kept attribute of the ship, or of its modules or charges, by one of
+			// its own attributes.
+			values := map[string]float64{}
+			for _, a := range t.DogmaAttributes {
+				values[allNames[a.AttributeID]] = a.Value
+			}
+			for _, e := range t.DogmaEffects {
+				for _, mi := range effectYml[e.EffectID].ModifierInfo {
+					modified := allNames[mi.ModifiedAttributeID]
+					modifying := allNames[mi.ModifyingAttributeID]
+					value, ok := values[modifying]
+					if !keepAttributes[modified] || !ok || mi.Operation < 0 || mi.Operation > 6 {
+						continue
+					}
+					m := Modifier{
+						Attribute: modified,
+						Operation: mi.Operation,
+						Value:     value,
+						Skill:     mi.SkillTypeID,
+						// The SDE doesn't say which bonuses scale with the ship's
+						// skills. Role bonuses are named as such; the rest are per
+						// level.
+						PerLevel: !strings.Contains(strings.ToLower(modifying), "role") &&
+							!strings.Contains(modifying, "PirateFaction"),
+					}
+					switch {
+					case (mi.Domain == "shipID" || mi.Domain == "itemID") && mi.Func == "ItemModifier":
+						m.Target = "ship"
+					case mi.Domain == "shipID" && mi.Func == "LocationRequiredSkillModifier":
+						m.Target = "module"
+					case mi.Domain == "shipID" && mi.Func == "LocationGroupModifier":
+						m.Target = "module"
+						m.Group = mi.GroupID
+					case mi.Domain == "shipID" && mi.Func == "LocationModifier":
+						m.Target = "module"
+					case mi.Domain == "charID" && mi.Func == "OwnerRequiredSkillModifier":
+						m.Target = "charge"
+					default:
+						continue
+					}
+					modifiers[id] = append(modifiers[id], m)
+				}
+			}
+		}
+	}
+
 	{
 		fmt.Println("reading types.yaml")
 		r, err := os.Open("sde/fsd/typeIDs.yaml")
@@ -99,8 +254,9 @@ func main() {
 			panic(err)
 		}
 		var yml map[int32]struct {
-			GroupID int32 `yaml:"groupID"`
-			Name    map[string]string
+			GroupID       int32 `yaml:"groupID"`
+			MarketGroupID int32 `yaml:"marketGroupID"`
+			Name          map[string]string
 		}
 		if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
 			panic(err)
@@ -118,14 +274,20 @@ func main() {
 		}
 		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
 		type Item struct {
-			ID    int32
-			Name  string
-			Lower string
-			Group int32
+			ID          int32
+			Name        string
+			Lower       string
+			Group       int32
+			Attributes  map[string]float64 `json:",omitempty"`
+			Effects     []string           `json:",omitempty"`
+			Modifiers   []Modifier         `json:",omitempty"`
+			MarketGroup int32              `json:",omitempty"`
 		}
 		asJson := map[int32]Item{}
 		for _, id := range ids {
 			m := yml[id]
+			typeGroups[id] = m.GroupID
+			typeNames[id] = m.Name["en"]
 			if !groups[m.GroupID] {
 				continue
 			}
@@ -134,11 +296,21 @@ func main() {
 			f.WriteString(sep)
 			sep = ","
 			fmt.Fprintf(f, "\n\t\t(%d, '%s', %d)", id, name, m.GroupID)
-			asJson[id] = Item{
-				ID:    id,
-				Name:  m.Name["en"],
-				Lower: strings.ToLower(m.Name["en"]),
-				Group: m.GroupID,
+			item := Item{
+				ID:          id,
+				Name:        m.Name["en"],
+				Lower:       strings.ToLower(m.Name["en"]),
+				Group:       m.GroupID,
+				Attributes:  attributes[id],
+				Effects:     effects[id],
+				MarketGroup: m.MarketGroupID,
+			}
+			if groupCategories[m.GroupID] == 6 {
+				item.Modifiers = modifiers[id]
+			}
+			asJson[id] = item
+			if m.MarketGroupID != 0 {
+				itemMarketGroups[id] = m.MarketGroupID
 			}
 		}
 		f.WriteString(";\n")
@@ -159,4 +331,362 @@ func main() {
 			panic(err)
 		}
 	}
+
+	{
+		fmt.Println("reading invNames.yaml")
+		r, err := os.Open("sde/bsd/invNames.yaml")
+		if err != nil {
+			panic(err)
+		}
+		var yml []struct {
+			ItemID   int32  `yaml:"itemID"`
+			ItemName string `yaml:"itemName"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		names := map[int32]string{}
+		for _, n := range yml {
+			names[n.ItemID] = n.ItemName
+		}
+
+		fmt.Println("reading universe")
+		type staticData struct {
+			RegionID        int32   `yaml:"regionID"`
+			ConstellationID int32   `yaml:"constellationID"`
+			SolarSystemID   int32   `yaml:"solarSystemID"`
+			Security        float64 `yaml:"security"`
+		}
+		// Directories nest as region/constellation/system, each with a
+		// staticdata file naming its ID.
+		regionDirs := map[string]int32{}
+		constellationDirs := map[string]int32{}
+		systemDirs := map[string]staticData{}
+		err = filepath.Walk("sde/fsd/universe", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			base := filepath.Base(path)
+			if !strings.HasSuffix(base, ".staticdata") {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			var d staticData
+			if err := yaml.NewDecoder(f).Decode(&d); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			dir := filepath.Dir(path)
+			switch base {
+			case "region.staticdata":
+				regionDirs[dir] = d.RegionID
+			case "constellation.staticdata":
+				constellationDirs[dir] = d.ConstellationID
+			case "solarsystem.staticdata":
+				systemDirs[dir] = d
+			}
+			return nil
+		})
+		if err != nil {
+			panic(err)
+		}
+		type Named struct {
+			ID    int32
+			Name  string
+			Lower string
+		}
+		type Constellation struct {
+			Named
+			Region int32
+		}
+		type System struct {
+			Named
+			Security      float64
+			Constellation int32
+			Region        int32
+		}
+		named := func(id int32) Named {
+			return Named{
+				ID:    id,
+				Name:  names[id],
+				Lower: strings.ToLower(names[id]),
+			}
+		}
+		var asJson struct {
+			Regions        map[int32]Named
+			Constellations map[int32]Constellation
+			Systems        map[int32]System
+		}
+		asJson.Regions = map[int32]Named{}
+		asJson.Constellations = map[int32]Constellation{}
+		asJson.Systems = map[int32]System{}
+		for _, id := range regionDirs {
+			asJson.Regions[id] = named(id)
+		}
+		for dir, id := range constellationDirs {
+			asJson.Constellations[id] = Constellation{
+				Named:  named(id),
+				Region: regionDirs[filepath.Dir(dir)],
+			}
+		}
+		for dir, d := range systemDirs {
+			constellation := constellationDirs[filepath.Dir(dir)]
+			asJson.Systems[d.SolarSystemID] = System{
+				Named:         named(d.SolarSystemID),
+				Security:      d.Security,
+				Constellation: constellation,
+				Region:        asJson.Constellations[constellation].Region,
+			}
+		}
+		f, err := os.Create("universe.json")
+		if err != nil {
+			panic(err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(asJson); err != nil {
+			panic(err)
+		}
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	{
+		fmt.Println("reading dynamicItemAttributes.yaml")
+		r, err := os.Open("sde/fsd/dynamicItemAttributes.yaml")
+		if err != nil {
+			panic(err)
+		}
+		// Keyed by mutaplasmid.
+		var yml map[int32]struct {
+			InputOutputMapping []struct {
+				ApplicableTypes []int32 `yaml:"applicableTypes"`
+				ResultingType   int32   `yaml:"resultingType"`
+			} `yaml:"inputOutputMapping"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		type Mutation struct {
+			Group        int32
+			Mutaplasmids []int32
+			Sources      []int32
+		}
+		type Item struct {
+			ID    int32
+			Name  string
+			Lower string
+		}
+		var asJson struct {
+			Mutations    map[int32]*Mutation
+			Mutaplasmids map[int32]Item
+		}
+		asJson.Mutations = map[int32]*Mutation{}
+		asJson.Mutaplasmids = map[int32]Item{}
+		for mutaplasmid, m := range yml {
+			asJson.Mutaplasmids[mutaplasmid] = Item{
+				ID:    mutaplasmid,
+				Name:  typeNames[mutaplasmid],
+				Lower: strings.ToLower(typeNames[mutaplasmid]),
+			}
+			for _, mapping := range m.InputOutputMapping {
+				if len(mapping.ApplicableTypes) == 0 {
+					continue
+				}
+				mut := asJson.Mutations[mapping.ResultingType]
+				if mut == nil {
+					mut = &Mutation{Group: typeGroups[mapping.ApplicableTypes[0]]}
+					asJson.Mutations[mapping.ResultingType] = mut
+				}
+				mut.Mutaplasmids = append(mut.Mutaplasmids, mutaplasmid)
+				mut.Sources = append(mut.Sources, mapping.ApplicableTypes...)
+			}
+		}
+		for _, mut := range asJson.Mutations {
+			sort.Slice(mut.Mutaplasmids, func(i, j int) bool { return mut.Mutaplasmids[i] < mut.Mutaplasmids[j] })
+			sort.Slice(mut.Sources, func(i, j int) bool { return mut.Sources[i] < mut.Sources[j] })
+			sources := mut.Sources[:0]
+			for i, id := range mut.Sources {
+				if i == 0 || id != mut.Sources[i-1] {
+					sources = append(sources, id)
+				}
+			}
+			mut.Sources = sources
+		}
+		f, err := os.Create("mutations.json")
+		if err != nil {
+			panic(err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(asJson); err != nil {
+			panic(err)
+		}
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}
+
+	{
+		fmt.Println("reading marketGroups.yaml")
+		r, err := os.Open("sde/fsd/marketGroups.yaml")
+		if err != nil {
+			panic(err)
+		}
+		var yml map[int32]struct {
+			NameID        map[string]string `yaml:"nameID"`
+			ParentGroupID int32             `yaml:"parentGroupID"`
+		}
+		if err := yaml.NewDecoder(r).Decode(&yml); err != nil {
+			panic(err)
+		}
+		r.Close()
+		type MarketGroup struct {
+			ID     int32
+			Name   string
+			Parent int32 `json:",omitempty"`
+		}
+		// Only keep the groups with items in items.json somewhere below them.
+		asJson := map[int32]MarketGroup{}
+		for _, id := range itemMarketGroups {
+			for id != 0 {
+				if _, ok := asJson[id]; ok {
+					break
+				}
+				m, ok := yml[id]
+				if !ok {
+					break
+				}
+				asJson[id] = MarketGroup{
+					ID:     id,
+					Name:   m.NameID["en"],
+					Parent: m.ParentGroupID,
+				}
+				id = m.ParentGroupID
+			}
+		}
+		f, err := os.Create("marketgroups.json")
+		if err != nil {
+			panic(err)
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(asJson); err != nil {
+			panic(err)
+		}
+		if err := f.Close(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Modifier is a hull bonus, written to items.json in the format main.go's
+// Modifier reads.
+type Modifier struct {
+	Target    string
+	Skill     int32 `json:",omitempty"`
+	Group     int32 `json:",omitempty"`
+	Attribute string
+	Operation int
+	Value     float64
+	PerLevel  bool `json:",omitempty"`
+}
+
+// keepAttributes are the dogma attributes written to items.json.
+var keepAttributes = map[string]bool{
+	// Ship slot layout, and what subsystems add to it.
+	"hiSlots":           true,
+	"medSlots":          true,
+	"lowSlots":          true,
+	"rigSlots":          true,
+	"maxSubSystems":     true,
+	"serviceSlots":      true,
+	"hiSlotModifier":    true,
+	"medSlotModifier":   true,
+	"lowSlotModifier":   true,
+	"turretSlotsLeft":   true,
+	"launcherSlotsLeft": true,
+
+	// Fitting resources: what ships have and modules use.
+	"cpuOutput":       true,
+	"powerOutput":     true,
+	"upgradeCapacity": true,
+	"cpu":             true,
+	"power":           true,
+	"upgradeCost":     true,
+	"metaLevel":       true,
+	"techLevel":       true,
+	"chargeSize":      true,
+	"chargeGroup1":    true,
+	"chargeGroup2":    true,
+	"chargeGroup3":    true,
+	"chargeGroup4":    true,
+	"chargeGroup5":    true,
+	// The skills hull bonuses to modules and charges require.
+	"requiredSkill1": true,
+	"requiredSkill2": true,
+	"requiredSkill3": true,
+
+	// Fitting modifiers: co-processors, reactor control units and auxiliary
+	// power cores.
+	"cpuMultiplier":         true,
+	"powerOutputMultiplier": true,
+	"powerIncrease":         true,
+	// Ancillary current routers and processor overclocking units.
+	"powerEngineeringOutputBonus": true,
+	"cpuOutputBonus2":             true,
+
+	// Weapon damage and rate of fire, and the modules that improve them.
+	"damageMultiplier":             true,
+	"speed":                        true,
+	"emDamage":                     true,
+	"explosiveDamage":              true,
+	"kineticDamage":                true,
+	"thermalDamage":                true,
+	"missileDamageMultiplierBonus": true,
+	"speedMultiplier":              true,
+
+	// Hit points and resistances, and the modules that add to them.
+	"shieldCapacity":                 true,
+	"armorHP":                        true,
+	"hp":                             true,
+	"shieldEmDamageResonance":        true,
+	"shieldExplosiveDamageResonance": true,
+	"shieldKineticDamageResonance":   true,
+	"shieldThermalDamageResonance":   true,
+	"armorEmDamageResonance":         true,
+	"armorExplosiveDamageResonance":  true,
+	"armorKineticDamageResonance":    true,
+	"armorThermalDamageResonance":    true,
+	"emDamageResonance":              true,
+	"explosiveDamageResonance":       true,
+	"kineticDamageResonance":         true,
+	"thermalDamageResonance":         true,
+	"armorHPBonusAdd":                true,
+	"capacityBonus":                  true,
+	"emDamageResistanceBonus":        true,
+	"explosiveDamageResistanceBonus": true,
+	"kineticDamageResistanceBonus":   true,
+	"thermalDamageResistanceBonus":   true,
+
+	// Speed and agility, and propulsion modules.
+	"maxVelocity":      true,
+	"mass":             true,
+	"agility":          true,
+	"speedFactor":      true,
+	"speedBoostFactor": true,
+	"massAddition":     true,
+}
+
+// keepEffects are the dogma effects written to items.json.
+var keepEffects = map[string]bool{
+	// Weapons, which fitting skills make cheaper to fit.
+	"turretFitted":   true,
+	"launcherFitted": true,
 }
@@ -32,7 +32,7 @@ CREATE VIEW killmail_results_root AS
 	WHERE
 		killmail_root.killmail = fits.killmail;
 
-CREATE TABLE queries (id INT8 not null, items jsonb NOT NULL);
+CREATE TABLE queries (id INT8 not null, items jsonb NOT NULL, filter jsonb NOT NULL);
 
 CREATE VIEW query_items AS
 SELECT id, value::INT4
@@ -57,18 +57,42 @@ FROM
     jsonb_array_elements(fits.data->'QueryItems');
 --CREATE INDEX ON fits_items (value);
 
+CREATE VIEW fits_mutated_items AS
+SELECT killmail, value::INT
+FROM
+    fits,
+    jsonb_array_elements(fits.data->'MutatedFrom');
+
+-- Queries with the mutated filter also match an item on fits with a mutated
+-- version of it. MutatedFrom never overlaps QueryItems, so no item is counted
+-- twice. MutatedFrom is every possible source of the fit's mutated modules, so
+-- one module could stand in for several queried items; at most one queried
+-- item may be matched through it.
 CREATE VIEW query_fits AS
 	SELECT
-		query_items.id,
-		fits_items.killmail,
-		count(*) AS found
+		id, killmail, sum(direct) + least(sum(mutated), 1) AS found
 	FROM
-		query_items, fits_items
-	WHERE
-		query_items.value = fits_items.value
+		(
+			SELECT
+				query_items.id, fits_items.killmail, 1 AS direct, 0 AS mutated
+			FROM
+				query_items, fits_items
+			WHERE
+				query_items.value = fits_items.value
+			UNION ALL
+			SELECT
+				query_items.id, fits_mutated_items.killmail, 0 AS direct, 1 AS mutated
+			FROM
+				query_items, queries, fits_mutated_items
+			WHERE
+				query_items.value = fits_mutated_items.value
+				AND query_items.id = queries.id
+				AND queries.filter->'mutated' = 'true'
+		)
 	GROUP BY
-		query_items.id, fits_items.killmail;
+		id, killmail;
 
+-- Queries without items match every fit, leaving it to their filter.
 CREATE VIEW query_matches AS
 	SELECT
 		query_fits.id, killmail
@@ -76,7 +100,123 @@ CREATE VIEW query_matches AS
 		query_fits, query_counts
 	WHERE
 		query_fits.id = query_counts.id
-		AND found = total;
+		AND found = total
+	UNION ALL
+	SELECT
+		query_counts.id, fits.killmail
+	FROM
+		query_counts, fits
+	WHERE
+		total = 0;
+
+CREATE VIEW fits_meta AS
+	SELECT
+		killmail,
+		(data->>'Time')::TIMESTAMPTZ AS time,
+		data->'System' AS system,
+		COALESCE(data->'Labels', '[]') AS labels,
+		COALESCE(data->'Solo', 'false') AS solo,
+		COALESCE(data->'NPC', 'false') AS npc,
+		COALESCE(data->'Awox', 'false') AS awox,
+		COALESCE(data->'Overfit', 'false') AS overfit,
+		(data->'Stats'->>'DPS')::FLOAT8 AS dps,
+		(data->'Stats'->'EHP'->>'uniform')::FLOAT8 AS ehp,
+		(data->'Stats'->>'Speed')::FLOAT8 AS speed,
+		(data->'Stats'->>'Align')::FLOAT8 AS align,
+		data->'Victim'->'Character' AS character,
+		data->'Victim'->'Corporation' AS corporation,
+		data->'Victim'->'Alliance' AS alliance
+	FROM
+		fits;
+
+-- A filter key that's absent doesn't restrict anything. Fits without stats
+-- never match a stats bound. sort_key is the stat the query sorts by, negated
+-- for align where less is better, or NULL if it sorts by newest.
+CREATE VIEW query_filtered AS
+	SELECT
+		query_matches.id,
+		query_matches.killmail,
+		CASE queries.filter->>'sort'
+			WHEN 'dps' THEN fits_meta.dps
+			WHEN 'ehp' THEN fits_meta.ehp
+			WHEN 'speed' THEN fits_meta.speed
+			WHEN 'align' THEN -fits_meta.align
+		END AS sort_key
+	FROM
+		query_matches, queries, fits_meta
+	WHERE
+		query_matches.id = queries.id
+		AND query_matches.killmail = fits_meta.killmail
+		AND (
+			queries.filter->>'after' IS NULL
+			OR fits_meta.time >= (queries.filter->>'after')::TIMESTAMPTZ
+		)
+		AND (
+			queries.filter->>'before' IS NULL
+			OR fits_meta.time < (queries.filter->>'before')::TIMESTAMPTZ
+		)
+		AND (
+			queries.filter->'systems' IS NULL
+			OR queries.filter->'systems' @> jsonb_build_array(fits_meta.system)
+		)
+		AND (queries.filter->'solo' IS NULL OR queries.filter->'solo' = fits_meta.solo)
+		AND (queries.filter->'npc' IS NULL OR queries.filter->'npc' = fits_meta.npc)
+		AND (queries.filter->'awox' IS NULL OR queries.filter->'awox' = fits_meta.awox)
+		AND (queries.filter->'overfit' IS NULL OR queries.filter->'overfit' = fits_meta.overfit)
+		-- zKillboard has used both "lowsec" and "loc:lowsec" style labels.
+		AND (
+			queries.filter->>'sec' IS NULL
+			OR fits_meta.labels @> jsonb_build_array(queries.filter->>'sec')
+			OR fits_meta.labels @> jsonb_build_array('loc:' || (queries.filter->>'sec'))
+		)
+		AND (
+			queries.filter->'labels' IS NULL
+			OR fits_meta.labels @> queries.filter->'labels'
+		)
+		AND (
+			queries.filter->'characters' IS NULL
+			OR queries.filter->'characters' @> jsonb_build_array(fits_meta.character)
+		)
+		AND (
+			queries.filter->'corporations' IS NULL
+			OR queries.filter->'corporations' @> jsonb_build_array(fits_meta.corporation)
+		)
+		AND (
+			queries.filter->'alliances' IS NULL
+			OR queries.filter->'alliances' @> jsonb_build_array(fits_meta.alliance)
+		)
+		AND (
+			queries.filter->'stats'->>'min_dps' IS NULL
+			OR fits_meta.dps >= (queries.filter->'stats'->>'min_dps')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'max_dps' IS NULL
+			OR fits_meta.dps <= (queries.filter->'stats'->>'max_dps')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'min_ehp' IS NULL
+			OR fits_meta.ehp >= (queries.filter->'stats'->>'min_ehp')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'max_ehp' IS NULL
+			OR fits_meta.ehp <= (queries.filter->'stats'->>'max_ehp')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'min_speed' IS NULL
+			OR fits_meta.speed >= (queries.filter->'stats'->>'min_speed')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'max_speed' IS NULL
+			OR fits_meta.speed <= (queries.filter->'stats'->>'max_speed')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'min_align' IS NULL
+			OR fits_meta.align >= (queries.filter->'stats'->>'min_align')::FLOAT8
+		)
+		AND (
+			queries.filter->'stats'->>'max_align' IS NULL
+			OR fits_meta.align <= (queries.filter->'stats'->>'max_align')::FLOAT8
+		);
 
 CREATE VIEW results AS
 	SELECT
@@ -87,11 +227,11 @@ CREATE VIEW results AS
 			SELECT
 				killmail
 			FROM
-				query_matches
+				query_filtered
 			WHERE
-				query_matches.id = queries.id
+				query_filtered.id = queries.id
 			ORDER BY
-				killmail DESC
+				sort_key DESC NULLS LAST, killmail DESC
 			LIMIT
 				100
 		);
@@ -106,3 +246,106 @@ CREATE MATERIALIZED VIEW killmail_results AS
 	WHERE
 		results.killmail = fits.killmail;
 CREATE INDEX ON killmail_results (query_id);
+
+CREATE VIEW fits_attacker_ships AS
+SELECT killmail, value::INT
+FROM
+    fits,
+    jsonb_array_elements(fits.data->'AttackerShips');
+
+CREATE VIEW fits_attacker_weapons AS
+SELECT killmail, value::INT
+FROM
+    fits,
+    jsonb_array_elements(fits.data->'AttackerWeapons');
+
+-- Number of fits with each item, including ships.
+CREATE MATERIALIZED VIEW item_counts AS
+	SELECT
+		value AS item, count(*) AS count
+	FROM
+		fits_items
+	GROUP BY
+		value;
+CREATE INDEX ON item_counts (item);
+
+-- For each item (including ships), how many losses with it each attacker ship
+-- and weapon type was on.
+CREATE MATERIALIZED VIEW counter_ships AS
+	SELECT
+		fits_items.value AS item, fits_attacker_ships.value AS ship, count(*) AS count
+	FROM
+		fits_items, fits_attacker_ships
+	WHERE
+		fits_items.killmail = fits_attacker_ships.killmail
+	GROUP BY
+		fits_items.value, fits_attacker_ships.value;
+CREATE INDEX ON counter_ships (item);
+
+CREATE MATERIALIZED VIEW counter_weapons AS
+	SELECT
+		fits_items.value AS item, fits_attacker_weapons.value AS weapon, count(*) AS count
+	FROM
+		fits_items, fits_attacker_weapons
+	WHERE
+		fits_items.killmail = fits_attacker_weapons.killmail
+	GROUP BY
+		fits_items.value, fits_attacker_weapons.value;
+CREATE INDEX ON counter_weapons (item);
+
+-- ISK dropped and destroyed on the losses with each item, including ships.
+CREATE MATERIALIZED VIEW item_loot AS
+	SELECT
+		fits_items.value AS item,
+		count(*) AS count,
+		sum(COALESCE((fits.data->'DroppedValue')::INT8, 0)) AS dropped_value,
+		sum(COALESCE((fits.data->'DestroyedValue')::INT8, 0)) AS destroyed_value
+	FROM
+		fits_items, fits
+	WHERE
+		fits_items.killmail = fits.killmail
+	GROUP BY
+		fits_items.value;
+CREATE INDEX ON item_loot (item);
+
+-- Every fitted module, whether it dropped, and the charge loaded in it.
+CREATE VIEW fits_modules AS
+	SELECT
+		killmail,
+		(value->'ID')::INT AS item,
+		COALESCE(value->'Dropped', 'false') = 'true' AS dropped,
+		(value->'Charge')::INT AS charge,
+		COALESCE((value->'ChargeQty')::INT, 0) AS charge_quantity
+	FROM
+		fits,
+		jsonb_array_elements(
+			(data->'Hi') || (data->'Med') || (data->'Lo') || (data->'Rig')
+			|| (data->'Sub') || COALESCE(data->'Service', '[]')
+		)
+	WHERE
+		value->'ID' IS NOT NULL;
+
+CREATE MATERIALIZED VIEW module_drops AS
+	SELECT
+		item,
+		count(*) AS count,
+		sum(CASE WHEN dropped THEN 1 ELSE 0 END) AS dropped
+	FROM
+		fits_modules
+	GROUP BY
+		item;
+CREATE INDEX ON module_drops (item);
+
+CREATE MATERIALIZED VIEW module_charges AS
+	SELECT
+		item,
+		charge,
+		count(*) AS count,
+		sum(charge_quantity) AS quantity
+	FROM
+		fits_modules
+	WHERE
+		charge IS NOT NULL
+	GROUP BY
+		item, charge;
+CREATE INDEX ON module_charges (item);
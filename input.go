@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// killmailSource yields raw killmail JSON from a process input file. Next
+// returns io.EOF when there are no more killmails.
+type killmailSource interface {
+	Next() ([]byte, error)
+	Close() error
+}
+
+// openInput opens path and detects its format from its contents: optionally
+// gzip or bzip2 compressed, then either a tar archive of one killmail per
+// .json file (like the everef daily dumps), JSON lines, or a CSV with a single
+// JSON column (like a Materialize export).
+func openInput(path string) (killmailSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	br := bufio.NewReaderSize(r, 1<<20)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch {
+	case len(head) == 512 && string(head[257:262]) == "ustar":
+		return &tarSource{f: f, tr: tar.NewReader(br)}, nil
+	case bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte("{")):
+		return &jsonlSource{f: f, r: br}, nil
+	default:
+		c := csv.NewReader(br)
+		c.FieldsPerRecord = 1
+		return &csvSource{f: f, r: c}, nil
+	}
+}
+
+func decompress(r *bufio.Reader) (io.Reader, error) {
+	magic, err := r.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(r), nil
+	default:
+		return r, nil
+	}
+}
+
+type csvSource struct {
+	f *os.File
+	r *csv.Reader
+}
+
+func (s *csvSource) Next() ([]byte, error) {
+	record, err := s.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(record[0]), nil
+}
+
+func (s *csvSource) Close() error {
+	return s.f.Close()
+}
+
+type jsonlSource struct {
+	f *os.File
+	r *bufio.Reader
+}
+
+func (s *jsonlSource) Next() ([]byte, error) {
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			return trimmed, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *jsonlSource) Close() error {
+	return s.f.Close()
+}
+
+type tarSource struct {
+	f  *os.File
+	tr *tar.Reader
+}
+
+func (s *tarSource) Next() ([]byte, error) {
+	for {
+		hdr, err := s.tr.Next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg || !strings.HasSuffix(hdr.Name, ".json") {
+			continue
+		}
+		return io.ReadAll(s.tr)
+	}
+}
+
+func (s *tarSource) Close() error {
+	return s.f.Close()
+}
+
+// decodeKillmail decodes either a zKillboard package or a bare ESI killmail
+// into z. ESI killmails have no zkb envelope unless one was added alongside the
+// killmail fields, so their FittedValue is usually zero.
+func decodeKillmail(raw []byte, z *ZkillboardKillmail) error {
+	*z = ZkillboardKillmail{}
+	var probe struct {
+		KillID *int            `json:"killID"`
+		Zkb    json.RawMessage `json:"zkb"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return err
+	}
+	if probe.KillID != nil {
+		return json.Unmarshal(raw, z)
+	}
+	if err := json.Unmarshal(raw, &z.Killmail); err != nil {
+		return err
+	}
+	if z.Killmail.KillmailID == 0 {
+		return fmt.Errorf("neither killID nor killmail_id found")
+	}
+	z.KillID = z.Killmail.KillmailID
+	if len(probe.Zkb) > 0 {
+		if err := json.Unmarshal(probe.Zkb, &z.Zkb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenInput(t *testing.T) {
+	gz := func(b []byte) []byte {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(b)
+		w.Close()
+		return buf.Bytes()
+	}
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for _, f := range []struct{ name, body string }{
+		{"2021/07/04/", ""},
+		{"2021/07/04/1.json", `{"killmail_id":1}`},
+		{"2021/07/04/README", "not a killmail"},
+		{"2021/07/04/2.json", `{"killmail_id":2}`},
+	} {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), Typeflag: tar.TypeReg}
+		if f.body == "" {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, f.body)
+	}
+	tw.Close()
+	jsonl := []byte("{\"killID\":1}\n\n  {\"killID\":2}\n{\"killID\":3}")
+	csv := []byte("\"{\"\"killID\"\":1}\"\n\"{\"\"killID\"\":2}\"\n")
+
+	tests := []struct {
+		name  string
+		input []byte
+		typ   interface{}
+		want  []string
+	}{
+		{"tar", tarball.Bytes(), &tarSource{}, []string{`{"killmail_id":1}`, `{"killmail_id":2}`}},
+		{"tar.gz", gz(tarball.Bytes()), &tarSource{}, []string{`{"killmail_id":1}`, `{"killmail_id":2}`}},
+		{"jsonl", jsonl, &jsonlSource{}, []string{`{"killID":1}`, `{"killID":2}`, `{"killID":3}`}},
+		{"jsonl.gz", gz(jsonl), &jsonlSource{}, []string{`{"killID":1}`, `{"killID":2}`, `{"killID":3}`}},
+		{"csv", csv, &csvSource{}, []string{`{"killID":1}`, `{"killID":2}`}},
+		{"empty", nil, &csvSource{}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "input")
+			writeFile(t, path, string(tc.input))
+			src, err := openInput(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer src.Close()
+			if reflect.TypeOf(src) != reflect.TypeOf(tc.typ) {
+				t.Errorf("got %T, want %T", src, tc.typ)
+			}
+			var got []string
+			for {
+				b, err := src.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, string(b))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeKillmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		killID int
+		ship   int
+		value  float64
+		err    bool
+	}{
+		{
+			name:   "zkillboard package",
+			raw:    `{"killID":1,"killmail":{"killmail_id":1,"victim":{"ship_type_id":587}},"zkb":{"fittedValue":10}}`,
+			killID: 1,
+			ship:   587,
+			value:  10,
+		},
+		{
+			name:   "esi killmail",
+			raw:    `{"killmail_id":2,"victim":{"ship_type_id":587}}`,
+			killID: 2,
+			ship:   587,
+		},
+		{
+			name:   "esi killmail with zkb",
+			raw:    `{"killmail_id":3,"victim":{"ship_type_id":587},"zkb":{"fittedValue":5}}`,
+			killID: 3,
+			ship:   587,
+			value:  5,
+		},
+		{
+			name: "neither",
+			raw:  `{"victim":{"ship_type_id":587}}`,
+			err:  true,
+		},
+		{
+			name: "invalid",
+			raw:  `{`,
+			err:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var z ZkillboardKillmail
+			// Leftovers from a previous killmail are cleared.
+			z.Zkb.FittedValue = 99
+			err := decodeKillmail([]byte(tc.raw), &z)
+			if tc.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if z.KillID != tc.killID || z.Killmail.Victim.ShipTypeID != tc.ship || z.Zkb.FittedValue != tc.value {
+				t.Errorf("got killID %d, ship %d, value %v", z.KillID, z.Killmail.Victim.ShipTypeID, z.Zkb.FittedValue)
+			}
+		})
+	}
+}
@@ -1,26 +1,179 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 )
 
-func read_json() {
-	for {
-		if err := read_json_record(); err != nil {
-			fmt.Println(err)
-			time.Sleep(time.Second * 10)
+// read_json logs to stderr so that stdout can be used as a sink. It runs until
+// SIGINT or SIGTERM, then saves the checkpoint.
+func read_json(spec Specification) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	r := makeReader(spec)
+	defer r.Close()
+	var err error
+	r.rq, err = makeRedisQ(spec)
+	if err != nil {
+		panic(err)
+	}
+	rand.Seed(time.Now().UnixNano())
+	b := backoff{min: spec.Backoff_Min, max: spec.Backoff_Max}
+	st := r.st
+	log.Printf("resuming %s sink: %d killmails written, %d duplicates skipped, %d dead-lettered, last killID %d", spec.Sink, st.Count, st.Skipped, st.deadLettered(), st.LastKillID)
+	log.Printf("listening on %s", r.rq.url)
+	for ctx.Err() == nil {
+		if err := read_json_record(ctx, r); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			d := b.Next()
+			log.Printf("%v; retrying in %s", err, d)
+			sleep(ctx, d)
+			continue
+		}
+		b.Reset()
+	}
+	log.Printf("stopping: %d killmails written", st.Count)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// reader is everything needed to handle a package, whether it came from
+// RedisQ or backfill.
+type reader struct {
+	rq         *redisq
+	sink       Sink
+	fits       Sink
+	st         *readState
+	deadLetter string
+	// A failed sink write is retried sinkRetries times with sinkBackoff.
+	sinkRetries int
+	sinkBackoff backoff
+}
+
+func makeReader(spec Specification) *reader {
+	sink, err := makeSink(spec)
+	if err != nil {
+		panic(err)
+	}
+	r := &reader{
+		sink:        sink,
+		deadLetter:  spec.Dead_Letter,
+		sinkRetries: spec.Sink_Retries,
+		sinkBackoff: backoff{min: spec.Backoff_Min, max: spec.Backoff_Max},
+	}
+	if spec.Read_Fits != "" {
+		s, err := LoadSDEData(spec.SDE_Path)
+		if err != nil {
+			panic(err)
+		}
+		opts, err := makeProcessOptions(spec)
+		if err != nil {
+			panic(err)
+		}
+		r.fits = &processSink{
+			s:    &s,
+			opts: opts,
+			next: &fileSink{path: spec.Read_Fits},
 		}
 	}
+	r.st, err = loadReadState(statePath(spec.Archive), spec.Read_Seen, sink)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r *reader) Close() {
+	if err := r.st.Close(); err != nil {
+		log.Printf("save checkpoint: %v", err)
+	}
+	r.sink.Close()
+	if r.fits != nil {
+		r.fits.Close()
+	}
+}
+
+// redisq is a client for a RedisQ queue.
+type redisq struct {
+	client *http.Client
+	url    string
 }
 
-func read_json_record() error {
-	resp, err := http.Get("https://redisq.zkillboard.com/listen.php?queueID=fittings-mz")
+func makeRedisQ(spec Specification) (*redisq, error) {
+	u, err := url.Parse(spec.RedisQ_URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("queueID", spec.Queue_ID)
+	if spec.TTW > 0 {
+		q.Set("ttw", strconv.Itoa(spec.TTW))
+	}
+	u.RawQuery = q.Encode()
+	return &redisq{
+		client: &http.Client{Timeout: spec.HTTP_Timeout},
+		url:    u.String(),
+	}, nil
+}
+
+// backoff is an exponential backoff with jitter. Each delay is picked at
+// random from the upper half of the current step, which doubles from min up to
+// max until Reset.
+type backoff struct {
+	min, max time.Duration
+	step     time.Duration
+}
+
+func (b *backoff) Next() time.Duration {
+	if b.step == 0 {
+		b.step = b.min
+	} else if b.step < b.max {
+		b.step *= 2
+	}
+	if b.step > b.max {
+		b.step = b.max
+	}
+	if b.step <= 0 {
+		return 0
+	}
+	half := b.step / 2
+	return half + time.Duration(rand.Int63n(int64(b.step-half)+1))
+}
+
+func (b *backoff) Reset() {
+	b.step = 0
+}
+
+// read_json_record writes one RedisQ package to the sink and, if fits is not
+// nil, its processed fit to fits. Packages that don't look like killmails go to
+// the dead-letter file instead.
+func read_json_record(ctx context.Context, r *reader) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", r.rq.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.rq.client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -30,31 +183,91 @@ func read_json_record() error {
 		return errors.New(resp.Status)
 	}
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
 	var pkg struct {
 		Package *json.RawMessage `json:"package"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&pkg); err != nil {
-		return err
+	if err := json.Unmarshal(body, &pkg); err != nil {
+		// RedisQ has already handed this package out, so retrying won't get it
+		// back.
+		return r.quarantine(body, 0, deadUndecodable)
 	}
 	if pkg.Package == nil {
-		return nil
+		// Nothing arrived, so it's a good time to save anything pending.
+		return r.st.Checkpoint()
 	}
-	f, err := os.OpenFile("zkillboard.json", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	return r.write(ctx, *pkg.Package)
+}
+
+// write checks, dedups and writes a package. Packages the sink won't take go
+// to the dead-letter file, since neither RedisQ nor backfill hands them out
+// again.
+func (r *reader) write(ctx context.Context, pkg []byte) error {
+	st := r.st
+	killID, reason := checkPackage(pkg)
+	if reason != "" {
+		return r.quarantine(pkg, killID, reason)
 	}
-	if _, err := f.Write(*pkg.Package); err != nil {
-		return err
+	if st.Has(killID) {
+		st.Skipped++
+		st.Changed()
+		log.Printf("skipped duplicate killID %d (%d skipped)", killID, st.Skipped)
+		return st.Checkpoint()
 	}
-	if _, err := f.WriteString("\n"); err != nil {
+	if err := r.writeSink(ctx, pkg); err != nil {
+		log.Printf("sink killID %d: %v", killID, err)
+		return r.quarantine(pkg, killID, deadSink)
+	}
+	if err := st.Add(killID); err != nil {
 		return err
 	}
-	if err := f.Close(); err != nil {
+	if p, ok := r.sink.(positioner); ok {
+		st.File, st.Offset = p.Position()
+	}
+	if err := st.Checkpoint(); err != nil {
 		return err
 	}
+	if r.fits != nil {
+		// The raw package is already archived, so a failure here is reported
+		// but doesn't cause a retry.
+		if err := r.fits.Write(pkg); err != nil {
+			log.Printf("process killID %d: %v", killID, err)
+		}
+	}
 	return nil
 }
 
+// writeSink writes pkg to the sink, retrying with a backoff up to sinkRetries
+// times or until ctx is done.
+func (r *reader) writeSink(ctx context.Context, pkg []byte) error {
+	b := r.sinkBackoff
+	for attempt := 0; ; attempt++ {
+		err := r.sink.Write(pkg)
+		if err == nil || attempt >= r.sinkRetries || ctx.Err() != nil {
+			return err
+		}
+		d := b.Next()
+		log.Printf("sink: %v; retrying in %s", err, d)
+		sleep(ctx, d)
+	}
+}
+
+func (r *reader) quarantine(pkg []byte, killID int, reason string) error {
+	if err := quarantine(r.deadLetter, pkg, killID, reason); err != nil {
+		return err
+	}
+	if r.st.DeadLetters == nil {
+		r.st.DeadLetters = map[string]int{}
+	}
+	r.st.DeadLetters[reason]++
+	r.st.Changed()
+	log.Printf("dead-lettered killID %d: %s (%d %s, %d total)", killID, reason, r.st.DeadLetters[reason], reason, r.st.deadLettered())
+	return r.st.Checkpoint()
+}
+
 /*
 CREATE SOURCE bytea_data
 FROM FILE '/home/mjibson/scratch/fit-mz/zkillboard.json'
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// flakySink fails its first failures writes.
+type flakySink struct {
+	failures int
+	written  []string
+}
+
+func (s *flakySink) Write(pkg json.RawMessage) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.written = append(s.written, string(pkg))
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+func TestReaderWriteRetries(t *testing.T) {
+	const pkg = `{"killID":1,"killmail":{"killmail_id":1,"victim":{"ship_type_id":587}},"zkb":{}}`
+	tests := []struct {
+		name     string
+		failures int
+		written  bool
+	}{
+		{"first try", 0, true},
+		{"retried", 3, true},
+		{"dead-lettered", 4, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sink := &flakySink{failures: tc.failures}
+			st, err := loadReadState(filepath.Join(dir, "state"), 10, sink)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			r := &reader{
+				sink:        sink,
+				st:          st,
+				deadLetter:  filepath.Join(dir, "dead.json"),
+				sinkRetries: 3,
+			}
+			if err := r.write(context.Background(), []byte(pkg)); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(sink.written) == 1; got != tc.written {
+				t.Fatalf("written %v, want %v", sink.written, tc.written)
+			}
+			if st.Has(1) != tc.written {
+				t.Errorf("seen %v, want %v", st.Has(1), tc.written)
+			}
+			dead, _ := os.ReadFile(r.deadLetter)
+			if tc.written != (len(dead) == 0) || !tc.written && !strings.Contains(string(dead), `"Reason":"sink"`) {
+				t.Errorf("dead letters %s", dead)
+			}
+			if !tc.written && st.DeadLetters[deadSink] != 1 {
+				t.Errorf("dead letter counts %v", st.DeadLetters)
+			}
+		})
+	}
+}
@@ -4,11 +4,14 @@ import (
 	"database/sql"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"github.com/lib/pq"
@@ -25,14 +28,84 @@ var INIT_SQL string
 type Specification struct {
 	Port    string `default:"4001"`
 	DB_Addr string `default:"postgres://materialize@localhost:6875/?sslmode=disable"`
+
+	// Archive is the file read appends RedisQ packages to with the file and
+	// segment sinks. The read checkpoint is always kept next to it in
+	// Archive.state, and the killIDs it remembers in Archive.state.seen.
+	Archive   string `default:"zkillboard.json"`
+	Read_Seen int    `default:"100000"`
+	// Sink is one of file, segment (one Archive file per day), stdout or db
+	// (insert into Sink_Table at $DB_ADDR).
+	Sink       string `default:"file"`
+	Sink_Table string `default:"zkillboard"`
+	// Sink_Retries is how many times a failed sink write is retried, with the
+	// Backoff_Min to Backoff_Max backoff, before the package is dead-lettered.
+	Sink_Retries int `default:"10"`
+	// Read_Fits, if set, makes read also process each killmail as it arrives
+	// and append the resulting fit to this file, in the format of out.json.
+	Read_Fits string
+
+	// RedisQ_URL and Queue_ID select the RedisQ queue read polls. TTW is how
+	// many seconds RedisQ waits for a killmail before returning an empty
+	// package; HTTP_Timeout must be longer than that. Errors are retried with
+	// an exponential backoff from Backoff_Min to Backoff_Max.
+	RedisQ_URL   string        `default:"https://redisq.zkillboard.com/listen.php"`
+	Queue_ID     string        `default:"fittings-mz"`
+	TTW          int           `default:"10"`
+	HTTP_Timeout time.Duration `default:"30s"`
+	Backoff_Min  time.Duration `default:"1s"`
+	Backoff_Max  time.Duration `default:"5m"`
+	// Dead_Letter is where read puts packages that can't be processed, along
+	// with why and when they were received.
+	Dead_Letter string `default:"zkillboard.dead.json"`
+
+	// History_URL serves zKillboard-style daily killID to hash maps at
+	// YYYYMMDD.json, ESI_URL serves killmails at killmails/ID/HASH/ and
+	// Zkb_URL serves each killmail's zkb block at ID/. With an empty Zkb_URL
+	// backfilled killmails only get a hash in zkb: no fittedValue, labels or
+	// locationID. Backfill makes at most Backfill_Rate requests per second.
+	History_URL   string  `default:"https://zkillboard.com/api/history/"`
+	ESI_URL       string  `default:"https://esi.evetech.net/latest/"`
+	Zkb_URL       string  `default:"https://zkillboard.com/api/killID/"`
+	Backfill_Rate float64 `default:"10"`
+
+	// Process_Workers is how many killmails process handles in parallel. It
+	// defaults to the number of CPUs.
+	Process_Workers int
+	// Reject_Log, if set, is where process writes the ID, ship and reason of
+	// every killmail it rejects.
+	Reject_Log string
+	// Rules decide which killmails process and read turn into fits. It's
+	// either JSON or a path to a JSON file of a Rule or list of them. The
+	// default accepts ships with at least one low slot module.
+	Rules string
+	// Process_Implants makes process and read record implants and boosters.
+	// With the default rules it also accepts capsules that had implants.
+	Process_Implants bool
+
+	// Names is the file of pilot, corporation and alliance names built by
+	// resolve and used by web.
+	Names string `default:"names.json"`
+
+	// SDE_Path is a directory of SDE JSON files (groups.json, items.json,
+	// universe.json, mutations.json and marketgroups.json) to use instead of
+	// the ones built into the binary, so new items don't need a rebuild. web
+	// reloads them on SIGHUP or when they change.
+	SDE_Path string
 }
 
 func usage() {
 	fmt.Println(`run with argument:
 	web: start webserver on $PORT at $DB_ADDR
 	init: initialize views at $DB_ADDR
-	read: append json from zkillboard into zkillboard.json
-	process: process csv from cli args into out.json files`)
+	read: write json from zkillboard to $SINK, skipping killmails already written;
+		if $READ_FITS is set, also append processed fits to it
+	backfill: like read, but for killmails from a date range in the past; zkb
+		data comes from $ZKB_URL, or is only the hash if that is empty
+	process: process killmail files from cli args into out.json; each may be a
+		csv export, json lines or a tar of killmail json files, optionally
+		gzip or bzip2 compressed
+	resolve: add names of pilots in processed fits files from cli args to $NAMES`)
 	os.Exit(1)
 }
 
@@ -53,13 +126,17 @@ func main() {
 
 	switch os.Args[1] {
 	case "web":
-		web(spec.Port, spec.DB_Addr)
+		web(spec.Port, spec.DB_Addr, spec.Names, spec.SDE_Path)
 	case "init":
 		init_db(spec.DB_Addr)
 	case "read":
-		read_json()
+		read_json(spec)
+	case "backfill":
+		backfill(spec, os.Args[2:])
 	case "process":
-		process(os.Args[2:])
+		process(spec, os.Args[2:])
+	case "resolve":
+		resolve(spec, os.Args[2:])
 	default:
 		usage()
 	}
@@ -95,22 +172,133 @@ func init_db(dbURL string) {
 }
 
 type SDEData struct {
-	Items  map[int]Item
-	Groups map[int]Group
+	Items        map[int]Item
+	Groups       map[int]Group
+	MarketGroups map[int]MarketGroup
+	Universe
+	Abyssal
+
+	// marketChildren are the market groups in each market group, with the
+	// roots under 0, and marketItems the ships and items Search would find
+	// directly in each. Groups without any such items below them are left
+	// out.
+	marketChildren map[int][]int
+	marketItems    map[int][]int
+}
+
+type Universe struct {
+	Regions        map[int]Region
+	Constellations map[int]Constellation
+	Systems        map[int]SolarSystem
 }
 
 func MakeSDEData() SDEData {
-	var s SDEData
-	if err := json.Unmarshal(GROUPS_JSON, &s.Groups); err != nil {
-		panic(err)
-	}
-	if err := json.Unmarshal(ITEMS_JSON, &s.Items); err != nil {
+	s, err := LoadSDEData("")
+	if err != nil {
 		panic(err)
 	}
 	return s
 }
 
+// sdeFiles are the SDE JSON files in an SDE_Path directory.
+var sdeFiles = []string{"groups.json", "items.json", "universe.json", "mutations.json", "marketgroups.json"}
+
+// LoadSDEData reads the SDE JSON files in dir. Files that aren't there, or all
+// of them if dir is empty, come from the ones built into the binary.
+func LoadSDEData(dir string) (SDEData, error) {
+	var s SDEData
+	for _, f := range []struct {
+		name     string
+		embedded []byte
+		dst      interface{}
+	}{
+		{sdeFiles[0], GROUPS_JSON, &s.Groups},
+		{sdeFiles[1], ITEMS_JSON, &s.Items},
+		{sdeFiles[2], UNIVERSE_JSON, &s.Universe},
+		{sdeFiles[3], MUTATIONS_JSON, &s.Abyssal},
+		{sdeFiles[4], MARKETGROUPS_JSON, &s.MarketGroups},
+	} {
+		b := f.embedded
+		if dir != "" {
+			data, err := os.ReadFile(filepath.Join(dir, f.name))
+			if err == nil {
+				b = data
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return s, err
+			}
+		}
+		if err := json.Unmarshal(b, f.dst); err != nil {
+			return s, fmt.Errorf("%s: %w", f.name, err)
+		}
+	}
+	s.indexMarketGroups()
+	return s, nil
+}
+
+func (s *SDEData) indexMarketGroups() {
+	s.marketChildren = map[int][]int{}
+	s.marketItems = map[int][]int{}
+	added := map[int]bool{}
+	for id, item := range s.Items {
+		if item.MarketGroup == 0 || searchCategories[s.Groups[item.Group].Category] == "" {
+			continue
+		}
+		s.marketItems[item.MarketGroup] = append(s.marketItems[item.MarketGroup], id)
+		for g := item.MarketGroup; g != 0 && !added[g]; g = s.MarketGroups[g].Parent {
+			added[g] = true
+			parent := s.MarketGroups[g].Parent
+			s.marketChildren[parent] = append(s.marketChildren[parent], g)
+		}
+	}
+}
+
+// MarketGroupFits sums counts, the number of fits with each item, over the
+// ships and items in each market group and all the groups below it. The total
+// is under 0.
+func (s *SDEData) MarketGroupFits(counts map[int]int) map[int]int {
+	fits := map[int]int{}
+	for g, items := range s.marketItems {
+		sum := 0
+		for _, item := range items {
+			sum += counts[item]
+		}
+		for ; ; g = s.MarketGroups[g].Parent {
+			fits[g] += sum
+			if g == 0 {
+				break
+			}
+		}
+	}
+	return fits
+}
+
+// sdeModTime is the latest modification time of the SDE JSON files in dir.
+func sdeModTime(dir string) time.Time {
+	var latest time.Time
+	for _, name := range sdeFiles {
+		fi, err := os.Stat(filepath.Join(dir, name))
+		if err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
 func (s *SDEData) NamedItem(id int) NamedItem {
+	if _, ok := s.Items[id]; !ok {
+		if m, ok := s.Mutations[id]; ok {
+			return NamedItem{
+				ID:   id,
+				Name: m.Name(s),
+			}
+		}
+		if m, ok := s.Mutaplasmids[id]; ok {
+			return NamedItem{
+				ID:   id,
+				Name: m.Name,
+			}
+		}
+	}
 	item := s.Items[id]
 	return NamedItem{
 		ID:   item.ID,
@@ -131,6 +319,102 @@ type Item struct {
 	Name  string
 	Lower string
 	Group int
+	// Attributes and Effects are the item's dogma attributes and effects by
+	// name, limited to the ones generate_init.go keeps.
+	Attributes map[string]float64 `json:",omitempty"`
+	Effects    []string           `json:",omitempty"`
+	// Modifiers are a ship's hull bonuses.
+	Modifiers   []Modifier `json:",omitempty"`
+	MarketGroup int        `json:",omitempty"`
+}
+
+func (i Item) HasEffect(name string) bool {
+	for _, e := range i.Effects {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresSkill is whether skill is one of the item's required skills. Only
+// the skills the item itself requires count, not their prerequisites.
+func (i Item) RequiresSkill(skill int) bool {
+	for _, attr := range []string{"requiredSkill1", "requiredSkill2", "requiredSkill3"} {
+		if int(i.Attributes[attr]) == skill {
+			return true
+		}
+	}
+	return false
+}
+
+// Modifier is a dogma modifier from a ship's hull bonus.
+type Modifier struct {
+	// Target is "ship" for the ship itself, "module" for its fitted modules
+	// and "charge" for their charges. Modules and charges are limited to the
+	// ones that require Skill or are in Group, if set.
+	Target    string
+	Skill     int `json:",omitempty"`
+	Group     int `json:",omitempty"`
+	Attribute string
+	// Operation is the dogma operation: 0 and 4 multiply by Value, 1 and 5
+	// divide by it, 2 and 3 add and subtract it, and 6 adds Value percent.
+	Operation int
+	Value     float64
+	// PerLevel is whether Value is per level of the ship's skill, rather than
+	// a role bonus.
+	PerLevel bool `json:",omitempty"`
+}
+
+// Abyssal is what the SDE says about mutated modules.
+type Abyssal struct {
+	// Mutations are keyed by the type of the mutated module.
+	Mutations    map[int]Mutation
+	Mutaplasmids map[int]Item
+}
+
+// Mutation is a type of module made by applying a mutaplasmid to another
+// module. Killmails don't say which mutaplasmid or source module was used, only
+// the mutated type, so these are all the possibilities.
+type Mutation struct {
+	Group        int
+	Mutaplasmids []int
+	Sources      []int
+}
+
+// Name is used for mutated types that aren't in the SDE's items.
+func (m Mutation) Name(s *SDEData) string {
+	return "Abyssal " + s.Groups[m.Group].Name
+}
+
+type Region struct {
+	ID    int
+	Name  string
+	Lower string
+}
+
+type Constellation struct {
+	ID     int
+	Name   string
+	Lower  string
+	Region int
+}
+
+type SolarSystem struct {
+	ID            int
+	Name          string
+	Lower         string
+	Security      float64
+	Constellation int
+	Region        int
+}
+
+// MarketGroup is a node of the market's tree of items. Root groups have no
+// Parent.
+type MarketGroup struct {
+	ID     int
+	Name   string
+	Parent int `json:",omitempty"`
 }
 
 type Group struct {
@@ -150,17 +434,117 @@ type NamedItem struct {
 
 type ItemCharge struct {
 	NamedItem
-	Charge *NamedItem `json:",omitempty"`
+	Charge    *NamedItem    `json:",omitempty"`
+	ChargeQty int           `json:",omitempty"`
+	Mutation  *ItemMutation `json:",omitempty"`
+	Dropped   bool          `json:",omitempty"`
+}
+
+type ItemAmmo struct {
+	NamedItem
+	Loaded int
+	Cargo  int
+}
+
+// ItemMutation is what a mutated module could have been made from.
+type ItemMutation struct {
+	Mutaplasmids []NamedItem
+	Sources      []NamedItem
+}
+
+// NamedSystem is a solar system and where it is.
+type NamedSystem struct {
+	NamedItem
+	Security      float64
+	Constellation NamedItem
+	Region        NamedItem
+}
+
+func (s *SDEData) NamedSystem(id int) *NamedSystem {
+	sys, ok := s.Systems[id]
+	if !ok {
+		if id == 0 {
+			return nil
+		}
+		return &NamedSystem{NamedItem: NamedItem{ID: id}}
+	}
+	c := s.Constellations[sys.Constellation]
+	r := s.Regions[sys.Region]
+	return &NamedSystem{
+		NamedItem:     NamedItem{ID: sys.ID, Name: sys.Name},
+		Security:      sys.Security,
+		Constellation: NamedItem{ID: c.ID, Name: c.Name},
+		Region:        NamedItem{ID: r.ID, Name: r.Name},
+	}
+}
+
+// NamedPilot is who flew a ship. Names are empty if they haven't been resolved.
+type NamedPilot struct {
+	Character   *NamedItem `json:",omitempty"`
+	Corporation *NamedItem `json:",omitempty"`
+	Alliance    *NamedItem `json:",omitempty"`
+	FinalBlow   bool       `json:",omitempty"`
+}
+
+func (n Names) NamedPilot(p DBPilot) NamedPilot {
+	named := func(id int) *NamedItem {
+		if id == 0 {
+			return nil
+		}
+		item := n.NamedItem(id)
+		return &item
+	}
+	return NamedPilot{
+		Character:   named(p.Character),
+		Corporation: named(p.Corporation),
+		Alliance:    named(p.Alliance),
+		FinalBlow:   p.FinalBlow,
+	}
+}
+
+type ItemQuantity struct {
+	NamedItem
+	Quantity int
 }
 
 type FittingsKillmail struct {
-	ID     int
-	Cost   int
-	Ship   Item
-	Hi     [8]ItemCharge
-	Med    [8]ItemCharge
-	Lo     [8]ItemCharge
-	Rig    [8]ItemCharge
-	Sub    [8]ItemCharge
-	Charge []Item
+	ID             int
+	Cost           int
+	DestroyedValue int          `json:",omitempty"`
+	DroppedValue   int          `json:",omitempty"`
+	TotalValue     int          `json:",omitempty"`
+	Time           *time.Time   `json:",omitempty"`
+	System         *NamedSystem `json:",omitempty"`
+	Location       int          `json:",omitempty"`
+	Labels         []string     `json:",omitempty"`
+	Solo           bool         `json:",omitempty"`
+	NPC            bool         `json:",omitempty"`
+	Awox           bool         `json:",omitempty"`
+	Victim         NamedPilot
+	// Attackers are only included for a single fit.
+	Attackers []NamedPilot `json:",omitempty"`
+	Ship      NamedItem
+	// Overfit is whether the fit uses more CPU, powergrid or calibration than
+	// its ship has with all skills at V.
+	Overfit bool `json:",omitempty"`
+	// Stats are estimates with all skills at V.
+	Stats *FitStats `json:",omitempty"`
+	// Fitting is only included for a single fit.
+	Fitting *FittingStats `json:",omitempty"`
+	// Slots is how many slots the ship has in each rack.
+	Slots   SlotLayout
+	Hi      [8]ItemCharge
+	Med     [8]ItemCharge
+	Lo      [8]ItemCharge
+	Rig     [8]ItemCharge
+	Sub     [8]ItemCharge
+	Service [8]ItemCharge
+	Charge  []NamedItem
+	Drone   []ItemQuantity `json:",omitempty"`
+	Fighter []ItemQuantity `json:",omitempty"`
+	Cargo   []ItemQuantity `json:",omitempty"`
+	// Ammo is every charge loaded in a module or carried in cargo.
+	Ammo    []ItemAmmo  `json:",omitempty"`
+	Implant []NamedItem `json:",omitempty"`
+	Booster []NamedItem `json:",omitempty"`
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMarketGroups(t *testing.T) {
+	s := &SDEData{
+		Items: map[int]Item{
+			587:   {ID: 587, Group: 25, MarketGroup: 64},
+			603:   {ID: 603, Group: 25, MarketGroup: 64},
+			11371: {ID: 11371, Group: 324, MarketGroup: 433},
+			484:   {ID: 484, Group: 55, MarketGroup: 9},
+			// Not something Search finds.
+			34: {ID: 34, Group: 18, MarketGroup: 1857},
+		},
+		Groups: map[int]Group{
+			25:  {Category: 6},
+			324: {Category: 6},
+			55:  {Category: 7},
+			18:  {Category: 4},
+		},
+		MarketGroups: map[int]MarketGroup{
+			4:    {ID: 4, Name: "Ships"},
+			1361: {ID: 1361, Name: "Frigates", Parent: 4},
+			64:   {ID: 64, Name: "Minmatar", Parent: 1361},
+			432:  {ID: 432, Name: "Assault Frigates", Parent: 1361},
+			433:  {ID: 433, Name: "Minmatar", Parent: 432},
+			9:    {ID: 9, Name: "Ship Equipment"},
+			1857: {ID: 1857, Name: "Minerals"},
+		},
+	}
+	s.indexMarketGroups()
+	fits := s.MarketGroupFits(map[int]int{587: 1, 603: 2, 11371: 4, 484: 8, 34: 16})
+	for _, tc := range []struct {
+		group    int
+		children []int
+		fits     int
+	}{
+		{0, []int{4, 9}, 15},
+		{4, []int{1361}, 7},
+		{1361, []int{64, 432}, 7},
+		{432, []int{433}, 4},
+		{64, nil, 3},
+		{1857, nil, 0},
+	} {
+		children := append([]int(nil), s.marketChildren[tc.group]...)
+		sort.Ints(children)
+		if !reflect.DeepEqual(children, tc.children) {
+			t.Errorf("%d: children %v, want %v", tc.group, children, tc.children)
+		}
+		if fits[tc.group] != tc.fits {
+			t.Errorf("%d: fits %d, want %d", tc.group, fits[tc.group], tc.fits)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Names maps character, corporation and alliance IDs to their names. It's
+// kept in a JSON file built offline by the resolve command, since those IDs
+// aren't in the SDE.
+type Names map[int]string
+
+// loadNames reads the names file at path. A missing file is empty.
+func loadNames(path string) (Names, error) {
+	names := Names{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return names, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (n Names) NamedItem(id int) NamedItem {
+	return NamedItem{
+		ID:   id,
+		Name: n[id],
+	}
+}
+
+// resolve adds the names of every pilot, corporation and alliance in the
+// processed fits files at paths to the names file, looking up the ones it
+// doesn't have yet with ESI.
+func resolve(spec Specification, paths []string) {
+	if len(paths) == 0 {
+		panic("empty paths")
+	}
+	names, err := loadNames(spec.Names)
+	if err != nil {
+		panic(err)
+	}
+	missing := map[int]struct{}{}
+	add := func(p DBPilot) {
+		for _, id := range []int{p.Character, p.Corporation, p.Alliance} {
+			if _, ok := names[id]; id != 0 && !ok {
+				missing[id] = struct{}{}
+			}
+		}
+	}
+	for _, path := range paths {
+		src, err := openInput(path)
+		if err != nil {
+			panic(err)
+		}
+		for {
+			raw, err := src.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				panic(fmt.Errorf("%s: %w", path, err))
+			}
+			var dbkm DBKillmail
+			if err := json.Unmarshal(raw, &dbkm); err != nil {
+				panic(fmt.Errorf("%s: %w", path, err))
+			}
+			add(dbkm.Victim)
+			for _, a := range dbkm.Attackers {
+				add(a)
+			}
+		}
+		src.Close()
+	}
+	ids := make([]int, 0, len(missing))
+	for id := range missing {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	fmt.Printf("resolving %d names\n", len(ids))
+
+	client := &http.Client{Timeout: spec.HTTP_Timeout}
+	// ESI accepts up to 1000 IDs per request.
+	for len(ids) > 0 {
+		n := 1000
+		if n > len(ids) {
+			n = len(ids)
+		}
+		if err := resolveNames(client, spec.ESI_URL, ids[:n], names); err != nil {
+			panic(err)
+		}
+		ids = ids[n:]
+		if err := writeJSONAtomic(spec.Names, names); err != nil {
+			panic(err)
+		}
+	}
+	fmt.Printf("%d names in %s\n", len(names), spec.Names)
+}
+
+// resolveNames looks up ids with ESI's universe/names endpoint. ESI fails the
+// whole request if any ID is unknown, so on a 404 the IDs are split in half
+// and retried until the bad ones are isolated and skipped.
+func resolveNames(client *http.Client, esiURL string, ids []int, names Names) error {
+	body, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(esiURL+"universe/names/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		if len(ids) == 1 {
+			fmt.Printf("unknown id %d\n", ids[0])
+			return nil
+		}
+		half := len(ids) / 2
+		if err := resolveNames(client, esiURL, ids[:half], names); err != nil {
+			return err
+		}
+		return resolveNames(client, esiURL, ids[half:], names)
+	case resp.StatusCode != http.StatusOK:
+		io.Copy(os.Stderr, resp.Body)
+		return errors.New(resp.Status)
+	}
+	var res []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	for _, r := range res {
+		names[r.ID] = r.Name
+	}
+	return nil
+}
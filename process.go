@@ -1,111 +1,523 @@
 package main
 
 import (
-	"encoding/csv"
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"runtime"
 	"sort"
+	"sync"
 	"time"
 )
 
-func process(paths []string) {
+// process runs processKillmail over every killmail in paths on a pool of
+// workers and writes the fits to out.json in input order, so the output is the
+// same regardless of the number of workers.
+func process(spec Specification, paths []string) {
 	if len(paths) == 0 {
 		panic("empty paths")
 	}
-	s := MakeSDEData()
+	workers := spec.Process_Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	s, err := LoadSDEData(spec.SDE_Path)
+	if err != nil {
+		panic(err)
+	}
+	opts, err := makeProcessOptions(spec)
+	if err != nil {
+		panic(err)
+	}
 	out, err := os.Create("out.json")
 	if err != nil {
 		panic(err)
 	}
-	j := json.NewEncoder(out)
-	var z ZkillboardKillmail
-	for _, path := range paths {
-		file, err := os.Open(path)
+	w := bufio.NewWriterSize(out, 1<<20)
+	var rejectLog *json.Encoder
+	if spec.Reject_Log != "" {
+		f, err := os.Create(spec.Reject_Log)
 		if err != nil {
 			panic(err)
 		}
-		csv := csv.NewReader(file)
-		csv.FieldsPerRecord = 1
-		for {
-			record, err := csv.Read()
-			if err == io.EOF {
-				break
-			} else if err != nil {
+		defer func() {
+			if err := f.Close(); err != nil {
 				panic(err)
 			}
-			if err := json.Unmarshal([]byte(record[0]), &z); err != nil {
-				panic(err)
-			}
-			dbkm, ok := processKillmail(&s, z)
+		}()
+		rejectLog = json.NewEncoder(f)
+	}
+
+	jobs := make(chan processJob, workers*16)
+	results := make(chan processResult, workers*16)
+	// window bounds the jobs read but not yet written, and so the results held
+	// in pending below, when one slow killmail holds up the rest.
+	window := make(chan struct{}, workers*64)
+	go readJobs(paths, jobs, window)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processJobs(&s, opts, jobs, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive out of order; hold on to them until all earlier ones have
+	// been written.
+	pending := map[int]processResult{}
+	next := 0
+	var counts [numRejections]int
+	start := time.Now()
+	progress := time.NewTicker(time.Second * 10)
+	defer progress.Stop()
+	report := func() {
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		fmt.Printf("%d records (%.0f/s): %d accepted, %d rejected\n", total, float64(total)/time.Since(start).Seconds(), counts[Accepted], total-counts[Accepted])
+	}
+	for results != nil {
+		select {
+		case res, ok := <-results:
 			if !ok {
-				continue
+				results = nil
+				break
 			}
-			if err := j.Encode(dbkm); err != nil {
-				panic(err)
+			pending[res.seq] = res
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-window
+				if res.err != nil {
+					panic(res.err)
+				}
+				counts[res.reason]++
+				if res.reason != Accepted {
+					if rejectLog != nil {
+						if err := rejectLog.Encode(res.rejected); err != nil {
+							panic(err)
+						}
+					}
+					continue
+				}
+				if _, err := w.Write(res.line); err != nil {
+					panic(err)
+				}
 			}
+		case <-progress.C:
+			report()
 		}
 	}
+	if len(pending) > 0 {
+		panic("unwritten results")
+	}
+	report()
+	printRejections(counts)
+	if err := w.Flush(); err != nil {
+		panic(err)
+	}
 	if err := out.Close(); err != nil {
 		panic(err)
 	}
 }
 
+type processJob struct {
+	seq int
+	raw []byte
+	err error
+}
+
+// processResult holds the encoded fit for a job, or why the killmail was
+// rejected.
+type processResult struct {
+	seq      int
+	line     []byte
+	reason   Rejection
+	rejected rejectedKillmail
+	err      error
+}
+
+// rejectedKillmail is a line of the rejection log.
+type rejectedKillmail struct {
+	ID     int
+	Ship   int
+	Reason Rejection
+}
+
+func printRejections(counts [numRejections]int) {
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return
+	}
+	fmt.Printf("%-20s %10s %7s\n", "reason", "count", "pct")
+	for r, c := range counts {
+		fmt.Printf("%-20s %10d %6.2f%%\n", Rejection(r), c, float64(c)*100/float64(total))
+	}
+}
+
+// readJobs sends every killmail in paths to jobs, numbered in order. A read
+// error is sent as a job so that it's reported in order, after which nothing
+// more is read. Each job takes a slot in window, which is given back once its
+// result is written.
+func readJobs(paths []string, jobs chan<- processJob, window chan<- struct{}) {
+	defer close(jobs)
+	seq := 0
+	for _, path := range paths {
+		src, err := openInput(path)
+		if err != nil {
+			window <- struct{}{}
+			jobs <- processJob{seq: seq, err: err}
+			return
+		}
+		for {
+			raw, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			window <- struct{}{}
+			if err != nil {
+				jobs <- processJob{seq: seq, err: fmt.Errorf("%s: %w", path, err)}
+				src.Close()
+				return
+			}
+			jobs <- processJob{seq: seq, raw: raw}
+			seq++
+		}
+		src.Close()
+	}
+}
+
+func processJobs(s *SDEData, opts processOptions, jobs <-chan processJob, results chan<- processResult) {
+	var z ZkillboardKillmail
+	for job := range jobs {
+		res := processResult{seq: job.seq, err: job.err}
+		if res.err == nil {
+			res.line, res.reason, res.err = processRaw(s, opts, job.raw, &z)
+			if res.reason != Accepted {
+				res.rejected = rejectedKillmail{
+					ID:     z.KillID,
+					Ship:   z.Killmail.Victim.ShipTypeID,
+					Reason: res.reason,
+				}
+			}
+		}
+		results <- res
+	}
+}
+
+// processRaw decodes and processes one killmail into z, returning its encoded
+// fit followed by a newline, exactly as a json.Encoder would write it.
+func processRaw(s *SDEData, opts processOptions, raw []byte, z *ZkillboardKillmail) ([]byte, Rejection, error) {
+	if err := decodeKillmail(raw, z); err != nil {
+		return nil, Accepted, err
+	}
+	dbkm, reason := processKillmail(s, opts, *z)
+	if reason != Accepted {
+		return nil, reason, nil
+	}
+	line, err := json.Marshal(dbkm)
+	if err != nil {
+		return nil, Accepted, err
+	}
+	return append(line, '\n'), Accepted, nil
+}
+
 type DBKillmail struct {
-	ID         int
-	Cost       int
-	Ship       int
-	Hi         [8]DBItem
-	Med        [8]DBItem
-	Lo         [8]DBItem
-	Rig        [8]DBItem
-	Sub        [8]DBItem
+	ID   int
+	Cost int
+	// DestroyedValue, DroppedValue and TotalValue are zKillboard's valuations
+	// of the whole killmail, including the ship and cargo.
+	DestroyedValue int        `json:",omitempty"`
+	DroppedValue   int        `json:",omitempty"`
+	TotalValue     int        `json:",omitempty"`
+	Time           *time.Time `json:",omitempty"`
+	System         int        `json:",omitempty"`
+	Location       int        `json:",omitempty"`
+	Labels         []string   `json:",omitempty"`
+	Solo           bool       `json:",omitempty"`
+	NPC            bool       `json:",omitempty"`
+	Awox           bool       `json:",omitempty"`
+	Victim         DBPilot
+	Attackers      []DBPilot `json:",omitempty"`
+	// AttackerShips and AttackerWeapons are the distinct ship and weapon types
+	// used by the attackers.
+	AttackerShips   []int `json:",omitempty"`
+	AttackerWeapons []int `json:",omitempty"`
+	Ship            int
+	Hi              [8]DBItem
+	Med             [8]DBItem
+	Lo              [8]DBItem
+	Rig             [8]DBItem
+	Sub             [8]DBItem
+	Service         [8]DBItem
+	Drone           []DBQuantity `json:",omitempty"`
+	Fighter         []DBQuantity `json:",omitempty"`
+	Cargo           []DBQuantity `json:",omitempty"`
+	// Implant and Booster are only recorded with PROCESS_IMPLANTS.
+	Implant    []int `json:",omitempty"`
+	Booster    []int `json:",omitempty"`
 	QueryItems []int
+	// MutatedFrom are the modules the fit's mutated modules could have been
+	// made from, other than ones already in QueryItems.
+	MutatedFrom []int `json:",omitempty"`
+	// Overfit is whether the fit uses more CPU, powergrid or calibration than
+	// its ship has with all skills at V.
+	Overfit bool `json:",omitempty"`
+	// Stats are estimates with all skills at V.
+	Stats *FitStats `json:",omitempty"`
 }
 
 type DBItem struct {
 	ID     int `json:",omitempty"`
 	Charge int `json:",omitempty"`
+	// ChargeQty is how many of Charge were loaded.
+	ChargeQty int  `json:",omitempty"`
+	Mutated   bool `json:",omitempty"`
+	// Dropped is whether the module survived the kill.
+	Dropped bool `json:",omitempty"`
+}
+
+// DBPilot is who flew a ship on a killmail. NPCs have no character.
+type DBPilot struct {
+	Character   int  `json:",omitempty"`
+	Corporation int  `json:",omitempty"`
+	Alliance    int  `json:",omitempty"`
+	FinalBlow   bool `json:",omitempty"`
+}
+
+// DBQuantity is a stack of items in a bay or cargo.
+type DBQuantity struct {
+	ID       int
+	Quantity int
+}
+
+// bay sums item quantities by type.
+type bay map[int]int
+
+// list returns the bay's contents sorted by ID.
+func (b bay) list() []DBQuantity {
+	if len(b) == 0 {
+		return nil
+	}
+	l := make([]DBQuantity, 0, len(b))
+	for id, q := range b {
+		l = append(l, DBQuantity{ID: id, Quantity: q})
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].ID < l[j].ID })
+	return l
+}
+
+// Rejection is why processKillmail didn't turn a killmail into a fit.
+type Rejection int
+
+const (
+	Accepted Rejection = iota
+	// RejectUnknownShip means the victim's ship isn't in the SDE.
+	RejectUnknownShip
+	// RejectCategory means no rule applies to the ship's category or group.
+	RejectCategory
+	// RejectNoModules means too few slots were occupied.
+	RejectNoModules
+	// RejectValue means the fitted value was too low.
+	RejectValue
+	// RejectLabel means the zkb npc, awox or solo flags didn't match.
+	RejectLabel
+
+	numRejections
+)
+
+var rejectionNames = [numRejections]string{
+	Accepted:          "accepted",
+	RejectUnknownShip: "unknown ship",
+	RejectCategory:    "ship category",
+	RejectNoModules:   "too few modules",
+	RejectValue:       "fitted value",
+	RejectLabel:       "zkb label",
+}
+
+func (r Rejection) String() string {
+	if r < 0 || r >= numRejections {
+		return fmt.Sprintf("Rejection(%d)", int(r))
+	}
+	return rejectionNames[r]
+}
+
+func (r Rejection) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// processOptions are the settings shared by everything that calls
+// processKillmail.
+type processOptions struct {
+	Rules RuleSet
+	// Implants records implants and boosters.
+	Implants bool
+}
+
+func makeProcessOptions(spec Specification) (processOptions, error) {
+	opts := processOptions{
+		Implants: spec.Process_Implants,
+	}
+	var err error
+	opts.Rules, err = loadRules(spec.Rules)
+	if err != nil {
+		return opts, err
+	}
+	if opts.Implants && spec.Rules == "" {
+		opts.Rules = append(RuleSet{}, defaultRules...)
+		opts.Rules = append(opts.Rules, capsuleRule)
+	}
+	return opts, nil
 }
 
-func processKillmail(s *SDEData, z ZkillboardKillmail) (km DBKillmail, ok bool) {
+func processKillmail(s *SDEData, opts processOptions, z ZkillboardKillmail) (km DBKillmail, reason Rejection) {
 	km = DBKillmail{
-		ID:   z.KillID,
-		Cost: int(z.Zkb.FittedValue),
+		ID:             z.KillID,
+		Cost:           int(z.Zkb.FittedValue),
+		DestroyedValue: int(z.Zkb.DestroyedValue),
+		DroppedValue:   int(z.Zkb.DroppedValue),
+		TotalValue:     int(z.Zkb.TotalValue),
+		System:         z.Killmail.SolarSystemID,
+		Location:       z.Zkb.LocationID,
+		Labels:         z.Zkb.Labels,
+		Solo:           z.Zkb.Solo,
+		NPC:            z.Zkb.NPC,
+		Awox:           z.Zkb.Awox,
+		Victim: DBPilot{
+			Character:   z.Killmail.Victim.CharacterID,
+			Corporation: z.Killmail.Victim.CorporationID,
+			Alliance:    z.Killmail.Victim.AllianceID,
+		},
+	}
+	attackerShips, attackerWeapons := map[int]struct{}{}, map[int]struct{}{}
+	for _, a := range z.Killmail.Attackers {
+		km.Attackers = append(km.Attackers, DBPilot{
+			Character:   a.CharacterID,
+			Corporation: a.CorporationID,
+			Alliance:    a.AllianceID,
+			FinalBlow:   a.FinalBlow,
+		})
+		if a.ShipTypeID != 0 {
+			attackerShips[a.ShipTypeID] = struct{}{}
+		}
+		if a.WeaponTypeID != 0 {
+			attackerWeapons[a.WeaponTypeID] = struct{}{}
+		}
+	}
+	km.AttackerShips = sortedKeys(attackerShips)
+	km.AttackerWeapons = sortedKeys(attackerWeapons)
+	if t := z.Killmail.KillmailTime; !t.IsZero() {
+		km.Time = &t
 	}
 	queryItems := map[int]struct{}{}
 	queryItems[z.Killmail.Victim.ShipTypeID] = struct{}{}
-	hasHi := false
-	if _, ok = s.MaybeNamedItem(z.Killmail.Victim.ShipTypeID); !ok {
-		return km, false
+	var occupied struct {
+		Hi, Med, Lo, Rig, Sub, Service [8]bool
+	}
+	drones, fighters, cargo := bay{}, bay{}, bay{}
+	implants, boosters := map[int]struct{}{}, map[int]struct{}{}
+	mutatedFrom := map[int]struct{}{}
+	if _, ok := s.MaybeNamedItem(z.Killmail.Victim.ShipTypeID); !ok {
+		return km, RejectUnknownShip
 	}
 	km.Ship = z.Killmail.Victim.ShipTypeID
 	for _, item := range z.Killmail.Victim.Items {
 		var offset int
 		var slot *[8]DBItem
+		var occ *[8]bool
+		var b bay
 		switch {
 		case item.Flag >= 11 && item.Flag <= 18:
 			offset = 11
 			slot = &km.Lo
-			hasHi = true
+			occ = &occupied.Lo
 		case item.Flag >= 19 && item.Flag <= 26:
 			offset = 19
 			slot = &km.Med
+			occ = &occupied.Med
 		case item.Flag >= 27 && item.Flag <= 34:
 			offset = 27
 			slot = &km.Hi
+			occ = &occupied.Hi
 		case item.Flag >= 92 && item.Flag <= 99:
 			offset = 92
 			slot = &km.Rig
+			occ = &occupied.Rig
 		case item.Flag >= 125 && item.Flag <= 132:
 			offset = 125
 			slot = &km.Sub
+			occ = &occupied.Sub
+		case item.Flag >= 164 && item.Flag <= 171:
+			offset = 164
+			slot = &km.Service
+			occ = &occupied.Service
+		case item.Flag == 87:
+			b = drones
+		case item.Flag >= 158 && item.Flag <= 163:
+			// The fighter bay and launch tubes.
+			b = fighters
+		case item.Flag == 5:
+			b = cargo
+		case item.Flag == 89 || item.Flag == 88:
+			if !opts.Implants {
+				continue
+			}
+			if _, ok := s.Items[item.ItemTypeID]; !ok {
+				continue
+			}
+			if item.Flag == 89 {
+				implants[item.ItemTypeID] = struct{}{}
+			} else {
+				boosters[item.ItemTypeID] = struct{}{}
+			}
+			queryItems[item.ItemTypeID] = struct{}{}
+			continue
 		default:
 			continue
 		}
+		if b != nil {
+			// Bays can hold anything, including types generate_init.go leaves
+			// out of items.json; those are kept by type ID so the bays aren't
+			// quietly incomplete.
+			b[item.ItemTypeID] += item.QuantityDropped + item.QuantityDestroyed
+			if item.Flag != 5 {
+				queryItems[item.ItemTypeID] = struct{}{}
+			}
+			continue
+		}
 		idx := item.Flag - offset
+		if m, ok := s.Mutations[item.ItemTypeID]; ok {
+			// Mutated modules have their own types, which may not be in the
+			// SDE's items, and are never charges.
+			slot[idx].ID = item.ItemTypeID
+			slot[idx].Mutated = true
+			slot[idx].Dropped = item.QuantityDropped > 0
+			occ[idx] = true
+			queryItems[item.ItemTypeID] = struct{}{}
+			for _, src := range m.Sources {
+				mutatedFrom[src] = struct{}{}
+			}
+			continue
+		}
 		sdeItem, ok := s.Items[item.ItemTypeID]
 		if !ok {
+			// Probably a module missing from the SDE; it still occupies the slot.
+			occ[idx] = true
 			continue
 		}
 		sdeGroup, ok := s.Groups[sdeItem.Group]
@@ -113,25 +525,66 @@ func processKillmail(s *SDEData, z ZkillboardKillmail) (km DBKillmail, ok bool)
 			continue
 		}
 		if sdeGroup.IsCharge() {
-			if _, ok := s.Items[sdeItem.ID]; !ok {
-				return km, false
-			}
 			slot[idx].Charge = sdeItem.ID
+			slot[idx].ChargeQty = item.QuantityDropped + item.QuantityDestroyed
 		} else {
 			slot[idx].ID = sdeItem.ID
+			slot[idx].Dropped = item.QuantityDropped > 0
+			occ[idx] = true
 		}
 		queryItems[item.ItemTypeID] = struct{}{}
 	}
-	if !hasHi {
-		return km, false
+	count := func(occ [8]bool) int {
+		n := 0
+		for _, o := range occ {
+			if o {
+				n++
+			}
+		}
+		return n
+	}
+	counts := rackCounts{
+		Hi:      count(occupied.Hi),
+		Med:     count(occupied.Med),
+		Lo:      count(occupied.Lo),
+		Rig:     count(occupied.Rig),
+		Sub:     count(occupied.Sub),
+		Service: count(occupied.Service),
+
+		Implants: len(implants) + len(boosters),
+	}
+	if reason := opts.Rules.Check(s, &z, counts); reason != Accepted {
+		return km, reason
+	}
+	km.Implant = sortedKeys(implants)
+	km.Booster = sortedKeys(boosters)
+	km.Drone = drones.list()
+	km.Fighter = fighters.list()
+	km.Cargo = cargo.list()
+	km.QueryItems = sortedKeys(queryItems)
+	for id := range queryItems {
+		delete(mutatedFrom, id)
+	}
+	km.MutatedFrom = sortedKeys(mutatedFrom)
+	if fitting, ok := s.Fitting(&km); ok {
+		km.Overfit = fitting.Overfit
+	}
+	if stats, ok := s.Stats(&km); ok {
+		km.Stats = &stats
+	}
+	return km, Accepted
+}
+
+func sortedKeys(m map[int]struct{}) []int {
+	if len(m) == 0 {
+		return nil
 	}
-	items := make([]int, 0, len(queryItems))
-	for item := range queryItems {
-		items = append(items, item)
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	sort.Ints(items)
-	km.QueryItems = items
-	return km, hasHi
+	sort.Ints(keys)
+	return keys
 }
 
 type ZkillboardKillmail struct {
@@ -142,16 +595,36 @@ type ZkillboardKillmail struct {
 		SolarSystemID int       `json:"solar_system_id"`
 		Victim        struct {
 			Items []struct {
-				Flag       int `json:"flag"`
-				ItemTypeID int `json:"item_type_id"`
+				Flag              int `json:"flag"`
+				ItemTypeID        int `json:"item_type_id"`
+				QuantityDropped   int `json:"quantity_dropped"`
+				QuantityDestroyed int `json:"quantity_destroyed"`
 			} `json:"items"`
-			ShipTypeID int `json:"ship_type_id"`
+			ShipTypeID    int `json:"ship_type_id"`
+			CharacterID   int `json:"character_id"`
+			CorporationID int `json:"corporation_id"`
+			AllianceID    int `json:"alliance_id"`
 		} `json:"victim"`
+		Attackers []struct {
+			CharacterID   int  `json:"character_id"`
+			CorporationID int  `json:"corporation_id"`
+			AllianceID    int  `json:"alliance_id"`
+			FinalBlow     bool `json:"final_blow"`
+			ShipTypeID    int  `json:"ship_type_id"`
+			WeaponTypeID  int  `json:"weapon_type_id"`
+		} `json:"attackers"`
 	} `json:"killmail"`
 	Zkb struct {
-		FittedValue float64 `json:"fittedValue"`
-		Hash        string  `json:"hash"`
-		Href        string  `json:"href"`
-		LocationID  int     `json:"locationID"`
+		FittedValue    float64  `json:"fittedValue"`
+		DestroyedValue float64  `json:"destroyedValue"`
+		DroppedValue   float64  `json:"droppedValue"`
+		TotalValue     float64  `json:"totalValue"`
+		Hash           string   `json:"hash"`
+		Href           string   `json:"href"`
+		LocationID     int      `json:"locationID"`
+		NPC            bool     `json:"npc"`
+		Awox           bool     `json:"awox"`
+		Solo           bool     `json:"solo"`
+		Labels         []string `json:"labels"`
 	} `json:"zkb"`
 }
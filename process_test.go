@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProcessWorkers(t *testing.T) {
+	dir := t.TempDir()
+	var jsonl, gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	for i := 1; i <= 600; i++ {
+		// Accepted fits, fits the default rules reject and unknown ships, spread
+		// across two files.
+		var items, ship string
+		switch i % 3 {
+		case 0:
+			ship = "587"
+			items = `{"item_type_id":2046,"flag":11,"quantity_destroyed":1},{"item_type_id":484,"flag":27,"quantity_dropped":1},{"item_type_id":178,"flag":27,"quantity_destroyed":100},{"item_type_id":439,"flag":19,"quantity_destroyed":1}`
+		case 1:
+			ship = "587"
+			items = `{"item_type_id":484,"flag":27,"quantity_dropped":1}`
+		case 2:
+			ship = "1"
+		}
+		line := fmt.Sprintf(`{"killID":%d,"killmail":{"killmail_id":%d,"solar_system_id":30000142,"victim":{"ship_type_id":%s,"items":[%s]},"attackers":[{"character_id":%d,"final_blow":true}]},"zkb":{"fittedValue":%d}}`+"\n", i, i, ship, items, i, i*1000)
+		if i <= 300 {
+			jsonl.WriteString(line)
+		} else {
+			zw.Write([]byte(line))
+		}
+	}
+	zw.Close()
+	paths := []string{filepath.Join(dir, "a.jsonl"), filepath.Join(dir, "b.jsonl.gz")}
+	writeFile(t, paths[0], jsonl.String())
+	writeFile(t, paths[1], gz.String())
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	run := func(workers int) []byte {
+		if err := os.Chdir(t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		process(Specification{Process_Workers: workers}, paths)
+		out, err := os.ReadFile("out.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	serial := run(1)
+	if n := bytes.Count(serial, []byte("\n")); n != 200 {
+		t.Fatalf("%d fits, want 200", n)
+	}
+	for i := 0; i < 3; i++ {
+		if parallel := run(8); !bytes.Equal(serial, parallel) {
+			t.Fatal("output with 8 workers differs from 1 worker")
+		}
+	}
+}
+
+func TestProcessKillmailBays(t *testing.T) {
+	s := testSDE()
+	s.Groups = map[int]Group{25: {Category: 6}, 40: {Category: 7}, 55: {Category: 7}}
+	// Types that aren't in the SDE data, like loot, are kept by ID.
+	raw := `{"killID":1,"killmail":{"killmail_id":1,"victim":{"ship_type_id":1,"items":[
+		{"item_type_id":11,"flag":11,"quantity_destroyed":1},
+		{"item_type_id":10,"flag":87,"quantity_destroyed":2},
+		{"item_type_id":10,"flag":87,"quantity_dropped":1},
+		{"item_type_id":99999,"flag":87,"quantity_dropped":1},
+		{"item_type_id":88888,"flag":5,"quantity_dropped":20}
+	]}},"zkb":{}}`
+	var z ZkillboardKillmail
+	if err := decodeKillmail([]byte(raw), &z); err != nil {
+		t.Fatal(err)
+	}
+	km, reason := processKillmail(s, processOptions{Rules: defaultRules}, z)
+	if reason != Accepted {
+		t.Fatalf("rejected: %s", reason)
+	}
+	if want := []DBQuantity{{ID: 10, Quantity: 3}, {ID: 99999, Quantity: 1}}; !reflect.DeepEqual(km.Drone, want) {
+		t.Errorf("drones %v, want %v", km.Drone, want)
+	}
+	if want := []DBQuantity{{ID: 88888, Quantity: 20}}; !reflect.DeepEqual(km.Cargo, want) {
+		t.Errorf("cargo %v, want %v", km.Cargo, want)
+	}
+	if want := []int{1, 10, 11, 99999}; !reflect.DeepEqual(km.QueryItems, want) {
+		t.Errorf("query items %v, want %v", km.QueryItems, want)
+	}
+	if got := fromDBQuantity(s, km.Cargo); got[0].ID != 88888 || got[0].Name != "" {
+		t.Errorf("unknown cargo %+v, want ID 88888 without a name", got[0])
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+)
+
+// Rule describes which killmails become fits. Zero values don't restrict
+// anything.
+type Rule struct {
+	// Categories and Groups, if set, are the victim ship categories and groups
+	// this rule applies to. ExcludeGroups are never accepted.
+	Categories    []int `json:",omitempty"`
+	Groups        []int `json:",omitempty"`
+	ExcludeGroups []int `json:",omitempty"`
+
+	// Minimum number of occupied slots per rack, and in all racks together.
+	// Slots with only a charge in them don't count.
+	MinHi      int `json:",omitempty"`
+	MinMed     int `json:",omitempty"`
+	MinLo      int `json:",omitempty"`
+	MinRig     int `json:",omitempty"`
+	MinSub     int `json:",omitempty"`
+	MinService int `json:",omitempty"`
+	MinModules int `json:",omitempty"`
+	// MinImplants is the minimum number of implants and boosters, which are
+	// only recorded with PROCESS_IMPLANTS.
+	MinImplants int `json:",omitempty"`
+
+	// MinValue is the minimum zkb fittedValue.
+	MinValue float64 `json:",omitempty"`
+
+	// NPC, Awox and Solo, if set, require the zkb flag of the same name to
+	// have that value.
+	NPC  *bool `json:",omitempty"`
+	Awox *bool `json:",omitempty"`
+	Solo *bool `json:",omitempty"`
+}
+
+// RuleSet accepts a killmail if any of its rules do.
+type RuleSet []Rule
+
+// defaultRules is what was hard-coded before rules were configurable, ships
+// with something in their low slots, plus structures with any module.
+var defaultRules = RuleSet{
+	{
+		Categories: []int{6},
+		MinLo:      1,
+	},
+	{
+		Categories: []int{65},
+		MinModules: 1,
+	},
+}
+
+// capsuleRule is added to the default rules when implants are recorded, so
+// that pod losses with implants become fits.
+var capsuleRule = Rule{
+	Groups:      []int{29},
+	MinImplants: 1,
+}
+
+// loadRules parses rules from JSON, either inline or from a file. It accepts a
+// single rule or a list of them. An empty string returns the default rules.
+func loadRules(s string) (RuleSet, error) {
+	if s == "" {
+		return defaultRules, nil
+	}
+	b := []byte(s)
+	if trimmed := bytes.TrimSpace(b); len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		var err error
+		b, err = os.ReadFile(s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && trimmed[0] == '{' {
+		var r Rule
+		if err := json.Unmarshal(b, &r); err != nil {
+			return nil, err
+		}
+		return RuleSet{r}, nil
+	}
+	var rs RuleSet
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// rackCounts is the number of occupied slots in each rack of a fit, and the
+// number of implants and boosters.
+type rackCounts struct {
+	Hi, Med, Lo, Rig, Sub, Service int
+
+	Implants int
+}
+
+func (c rackCounts) total() int {
+	return c.Hi + c.Med + c.Lo + c.Rig + c.Sub + c.Service
+}
+
+// Check returns Accepted if any rule accepts the killmail. Otherwise it
+// returns why the first rule that applies to the ship rejected it.
+func (rs RuleSet) Check(s *SDEData, z *ZkillboardKillmail, counts rackCounts) Rejection {
+	reason := RejectCategory
+	for _, r := range rs {
+		res := r.Check(s, z, counts)
+		if res == Accepted {
+			return Accepted
+		}
+		if reason == RejectCategory {
+			reason = res
+		}
+	}
+	return reason
+}
+
+func (r Rule) Check(s *SDEData, z *ZkillboardKillmail, counts rackCounts) Rejection {
+	group := s.Items[z.Killmail.Victim.ShipTypeID].Group
+	if len(r.Categories) > 0 && !containsInt(r.Categories, s.Groups[group].Category) {
+		return RejectCategory
+	}
+	if len(r.Groups) > 0 && !containsInt(r.Groups, group) {
+		return RejectCategory
+	}
+	if containsInt(r.ExcludeGroups, group) {
+		return RejectCategory
+	}
+	if counts.Hi < r.MinHi ||
+		counts.Med < r.MinMed ||
+		counts.Lo < r.MinLo ||
+		counts.Rig < r.MinRig ||
+		counts.Sub < r.MinSub ||
+		counts.Service < r.MinService ||
+		counts.total() < r.MinModules ||
+		counts.Implants < r.MinImplants {
+		return RejectNoModules
+	}
+	if z.Zkb.FittedValue < r.MinValue {
+		return RejectValue
+	}
+	if !matchFlag(r.NPC, z.Zkb.NPC) ||
+		!matchFlag(r.Awox, z.Zkb.Awox) ||
+		!matchFlag(r.Solo, z.Zkb.Solo) {
+		return RejectLabel
+	}
+	return Accepted
+}
+
+func matchFlag(want *bool, have bool) bool {
+	return want == nil || *want == have
+}
+
+func containsInt(list []int, v int) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
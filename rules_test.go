@@ -0,0 +1,138 @@
+package main
+
+import "testing"
+
+func TestRuleSetCheck(t *testing.T) {
+	s := &SDEData{
+		Items: map[int]Item{
+			587: {ID: 587, Group: 25},
+			670: {ID: 670, Group: 29},
+		},
+		Groups: map[int]Group{
+			25: {Category: 6},
+			29: {Category: 6},
+		},
+	}
+	yes := true
+	tests := []struct {
+		name   string
+		rules  RuleSet
+		ship   int
+		counts rackCounts
+		value  float64
+		solo   bool
+		want   Rejection
+	}{
+		{
+			name:   "default accepts",
+			rules:  defaultRules,
+			ship:   587,
+			counts: rackCounts{Lo: 1},
+			want:   Accepted,
+		},
+		{
+			name:   "default needs a low slot",
+			rules:  defaultRules,
+			ship:   587,
+			counts: rackCounts{Hi: 3},
+			want:   RejectNoModules,
+		},
+		{
+			name:  "no rule applies",
+			rules: RuleSet{{Categories: []int{65}}},
+			ship:  587,
+			want:  RejectCategory,
+		},
+		{
+			name:  "excluded group",
+			rules: RuleSet{{Categories: []int{6}, ExcludeGroups: []int{29}}},
+			ship:  670,
+			want:  RejectCategory,
+		},
+		{
+			name:   "first applicable rule's reason",
+			rules:  RuleSet{{Groups: []int{29}}, {Groups: []int{25}, MinValue: 100}, {Categories: []int{6}, MinHi: 1}},
+			ship:   587,
+			counts: rackCounts{Lo: 1},
+			value:  50,
+			want:   RejectValue,
+		},
+		{
+			name:   "later rule accepts",
+			rules:  RuleSet{{Groups: []int{25}, MinValue: 100}, {Categories: []int{6}, MinLo: 1}},
+			ship:   587,
+			counts: rackCounts{Lo: 1},
+			want:   Accepted,
+		},
+		{
+			name:   "min modules across racks",
+			rules:  RuleSet{{MinModules: 3}},
+			ship:   587,
+			counts: rackCounts{Hi: 1, Med: 1, Service: 1},
+			want:   Accepted,
+		},
+		{
+			name:   "implants don't count as modules",
+			rules:  RuleSet{{MinModules: 1}},
+			ship:   670,
+			counts: rackCounts{Implants: 2},
+			want:   RejectNoModules,
+		},
+		{
+			name:   "capsule rule",
+			rules:  append(append(RuleSet{}, defaultRules...), capsuleRule),
+			ship:   670,
+			counts: rackCounts{Implants: 1},
+			want:   Accepted,
+		},
+		{
+			name:  "flag",
+			rules: RuleSet{{Solo: &yes}},
+			ship:  587,
+			want:  RejectLabel,
+		},
+		{
+			name:  "flag matches",
+			rules: RuleSet{{Solo: &yes}},
+			ship:  587,
+			solo:  true,
+			want:  Accepted,
+		},
+		{
+			name: "no rules",
+			ship: 587,
+			want: RejectCategory,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var z ZkillboardKillmail
+			z.Killmail.Victim.ShipTypeID = tc.ship
+			z.Zkb.FittedValue = tc.value
+			z.Zkb.Solo = tc.solo
+			if got := tc.rules.Check(s, &z, tc.counts); got != tc.want {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	rs, err := loadRules(`{"Groups":[29],"MinImplants":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].MinImplants != 1 {
+		t.Errorf("single rule: %+v", rs)
+	}
+	rs, err = loadRules(`[{"MinLo":1},{"MinHi":2}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 2 || rs[1].MinHi != 2 {
+		t.Errorf("list: %+v", rs)
+	}
+	if rs, err := loadRules(""); err != nil || len(rs) != len(defaultRules) {
+		t.Errorf("default: %+v %v", rs, err)
+	}
+}
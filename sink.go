@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Sink is where read writes RedisQ packages.
+type Sink interface {
+	Write(pkg json.RawMessage) error
+	Close() error
+}
+
+// positioner is implemented by sinks backed by a file. It reports the file
+// last written to and its size after that write, which lets the checkpoint
+// recover anything written after it was saved.
+type positioner interface {
+	Position() (path string, offset int64)
+}
+
+func makeSink(spec Specification) (Sink, error) {
+	switch spec.Sink {
+	case "file":
+		return &fileSink{path: spec.Archive}, nil
+	case "segment":
+		return &segmentSink{base: spec.Archive}, nil
+	case "stdout":
+		return stdoutSink{}, nil
+	case "db":
+		return makeDBSink(spec.DB_Addr, spec.Sink_Table)
+	default:
+		return nil, fmt.Errorf("unknown sink %q", spec.Sink)
+	}
+}
+
+// processSink runs each package through processKillmail and writes the
+// resulting DBKillmail to next, in the same format process writes out.json.
+// Killmails that aren't fits are dropped.
+type processSink struct {
+	s    *SDEData
+	opts processOptions
+	next Sink
+}
+
+func (p *processSink) Write(pkg json.RawMessage) error {
+	var z ZkillboardKillmail
+	if err := decodeKillmail(pkg, &z); err != nil {
+		return err
+	}
+	dbkm, reason := processKillmail(p.s, p.opts, z)
+	if reason != Accepted {
+		return nil
+	}
+	b, err := json.Marshal(dbkm)
+	if err != nil {
+		return err
+	}
+	return p.next.Write(b)
+}
+
+func (p *processSink) Close() error {
+	return p.next.Close()
+}
+
+// fileSink appends to a single file.
+type fileSink struct {
+	path   string
+	offset int64
+}
+
+func (s *fileSink) Write(pkg json.RawMessage) error {
+	offset, err := appendLine(s.path, pkg)
+	if err != nil {
+		return err
+	}
+	s.offset = offset
+	return nil
+}
+
+func (s *fileSink) Position() (string, int64) {
+	return s.path, s.offset
+}
+
+func (s *fileSink) Close() error {
+	return nil
+}
+
+// segmentSink appends to one file per UTC day, named by inserting the date
+// before the extension of base: zkillboard.json becomes
+// zkillboard.2021-07-04.json.
+type segmentSink struct {
+	base   string
+	path   string
+	offset int64
+}
+
+func (s *segmentSink) segment(t time.Time) string {
+	ext := filepath.Ext(s.base)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(s.base, ext), t.UTC().Format("2006-01-02"), ext)
+}
+
+func (s *segmentSink) Write(pkg json.RawMessage) error {
+	path := s.segment(time.Now())
+	offset, err := appendLine(path, pkg)
+	if err != nil {
+		return err
+	}
+	s.path = path
+	s.offset = offset
+	return nil
+}
+
+func (s *segmentSink) Position() (string, int64) {
+	if s.path == "" {
+		return s.segment(time.Now()), 0
+	}
+	return s.path, s.offset
+}
+
+func (s *segmentSink) Close() error {
+	return nil
+}
+
+// stdoutSink writes one package per line to stdout for piping.
+type stdoutSink struct{}
+
+func (stdoutSink) Write(pkg json.RawMessage) error {
+	if _, err := os.Stdout.Write(pkg); err != nil {
+		return err
+	}
+	_, err := os.Stdout.WriteString("\n")
+	return err
+}
+
+func (stdoutSink) Close() error {
+	return nil
+}
+
+// dbSink inserts each package into a single JSONB column table.
+type dbSink struct {
+	db     *sql.DB
+	insert string
+}
+
+func makeDBSink(dbURL, table string) (*dbSink, error) {
+	db := init_sql(dbURL)
+	table = pq.QuoteIdentifier(table)
+	if _, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (data jsonb NOT NULL)`, table)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &dbSink{
+		db:     db,
+		insert: fmt.Sprintf(`INSERT INTO %s VALUES ($1)`, table),
+	}, nil
+}
+
+func (s *dbSink) Write(pkg json.RawMessage) error {
+	_, err := s.db.Exec(s.insert, string(pkg))
+	return err
+}
+
+func (s *dbSink) Close() error {
+	return s.db.Close()
+}
+
+// appendLine appends b and a newline to path, returning the file size
+// afterward.
+func appendLine(path string, b []byte) (int64, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if _, err := f.WriteString("\n"); err != nil {
+		f.Close()
+		return 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	return fi.Size(), f.Close()
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// readState is the checkpoint kept for the read sink. It remembers which
+// killIDs have already been written so that restarts and RedisQ redeliveries
+// don't append the same killmail twice.
+//
+// The remembered killIDs are appended to a separate log as they're added,
+// rather than rewritten with the rest of the checkpoint, and the checkpoint
+// itself is only saved every checkpointEvery changes or checkpointInterval.
+// After a crash, killIDs a file sink wrote past the last checkpoint are
+// recovered from the file; other sinks may write those again.
+type readState struct {
+	LastKillID int
+	Count      int
+	Skipped    int
+	// DeadLetters counts quarantined packages by reason.
+	DeadLetters map[string]int
+	// File and Offset are the file sink position as of the last checkpoint.
+	// Anything past it was written after the checkpoint and is rescanned on
+	// startup.
+	File   string
+	Offset int64
+	// Seen is only read, from checkpoints written before the seen log, and
+	// moved into the log.
+	Seen []int `json:",omitempty"`
+
+	path string
+	max  int
+	seen map[int]struct{}
+	// order is the remembered killIDs, oldest first.
+	order []int
+	// log is the seen log, which has logged lines.
+	log     *os.File
+	logged  int
+	pending int
+	saved   time.Time
+}
+
+const (
+	checkpointEvery    = 1000
+	checkpointInterval = 5 * time.Second
+)
+
+func statePath(archive string) string {
+	return archive + ".state"
+}
+
+func seenPath(state string) string {
+	return state + ".seen"
+}
+
+// loadReadState loads the checkpoint at path, creating an empty one if it
+// doesn't exist yet. At most max killIDs are remembered.
+func loadReadState(path string, max int, sink Sink) (*readState, error) {
+	st := &readState{
+		path:  path,
+		max:   max,
+		seen:  map[int]struct{}{},
+		saved: time.Now(),
+	}
+	b, err := os.ReadFile(st.path)
+	if err == nil {
+		if err := json.Unmarshal(b, st); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	ids, err := readSeenLog(seenPath(path))
+	if err != nil {
+		return nil, err
+	}
+	st.logged = len(ids)
+	for _, id := range ids {
+		st.remember(id)
+	}
+	st.log, err = os.OpenFile(seenPath(path), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if len(st.Seen) > 0 {
+		for _, id := range st.Seen {
+			if !st.Has(id) {
+				if err := st.logSeen(id); err != nil {
+					return nil, err
+				}
+			}
+		}
+		st.Seen = nil
+		st.pending++
+	}
+	if st.File == "" {
+		// A new checkpoint for an existing archive picks up everything already
+		// in it.
+		if p, ok := sink.(positioner); ok {
+			st.File, _ = p.Position()
+		}
+	}
+	if st.File != "" {
+		if err := st.recover(); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+// readSeenLog reads the killIDs in the seen log at path, oldest first. A
+// partial last line from a crash is ignored.
+func readSeenLog(path string) ([]int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ids []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id, err := strconv.Atoi(scanner.Text())
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+// recover picks up any killmails appended to File after the last checkpoint
+// was written, for example if we crashed between the two writes. All of them
+// are counted, even the ones that made it into the seen log.
+func (st *readState) recover() error {
+	f, err := os.Open(st.File)
+	if errors.Is(err, os.ErrNotExist) {
+		st.Offset = 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < st.Offset {
+		// The file was truncated or replaced; nothing past the offset to
+		// recover.
+		st.Offset = fi.Size()
+		return nil
+	}
+	if _, err := f.Seek(st.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var pkg struct {
+			KillID int `json:"killID"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &pkg); err != nil || pkg.KillID == 0 {
+			continue
+		}
+		if !st.Has(pkg.KillID) {
+			if err := st.logSeen(pkg.KillID); err != nil {
+				return err
+			}
+		}
+		st.count(pkg.KillID)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	st.Offset = fi.Size()
+	return nil
+}
+
+func (st *readState) Has(killID int) bool {
+	_, ok := st.seen[killID]
+	return ok
+}
+
+// Add records killID as written. The oldest killIDs are forgotten once more
+// than max are remembered.
+func (st *readState) Add(killID int) error {
+	if err := st.logSeen(killID); err != nil {
+		return err
+	}
+	st.count(killID)
+	return nil
+}
+
+func (st *readState) count(killID int) {
+	st.Count++
+	if killID > st.LastKillID {
+		st.LastKillID = killID
+	}
+	st.pending++
+}
+
+// logSeen appends killID to the seen log and remembers it.
+func (st *readState) logSeen(killID int) error {
+	if _, err := fmt.Fprintln(st.log, killID); err != nil {
+		return err
+	}
+	st.logged++
+	st.remember(killID)
+	return nil
+}
+
+func (st *readState) remember(killID int) {
+	st.seen[killID] = struct{}{}
+	st.order = append(st.order, killID)
+	if st.max > 0 && len(st.order) > st.max {
+		n := len(st.order) - st.max
+		for _, id := range st.order[:n] {
+			delete(st.seen, id)
+		}
+		st.order = append(st.order[:0], st.order[n:]...)
+	}
+}
+
+func (st *readState) deadLettered() int {
+	n := 0
+	for _, c := range st.DeadLetters {
+		n += c
+	}
+	return n
+}
+
+// Changed notes a change to the checkpoint other than Add, like a skipped
+// duplicate.
+func (st *readState) Changed() {
+	st.pending++
+}
+
+// Checkpoint saves the checkpoint if enough has changed or enough time has
+// passed since it was last saved.
+func (st *readState) Checkpoint() error {
+	if st.pending >= checkpointEvery || (st.pending > 0 && time.Since(st.saved) >= checkpointInterval) {
+		return st.Save()
+	}
+	return nil
+}
+
+// Save syncs the seen log, compacting it once it holds twice as many killIDs
+// as are remembered, and atomically writes the checkpoint.
+func (st *readState) Save() error {
+	if st.max > 0 && st.logged > 2*st.max {
+		if err := st.compact(); err != nil {
+			return err
+		}
+	} else if err := st.log.Sync(); err != nil {
+		return err
+	}
+	if err := writeJSONAtomic(st.path, st); err != nil {
+		return err
+	}
+	st.pending = 0
+	st.saved = time.Now()
+	return nil
+}
+
+// compact replaces the seen log with only the remembered killIDs.
+func (st *readState) compact() error {
+	path := seenPath(st.path)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, id := range st.order {
+		fmt.Fprintln(w, id)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	st.log.Close()
+	st.log, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st.logged = len(st.order)
+	return syncDir(path)
+}
+
+// Close saves the checkpoint if anything changed and closes the seen log.
+func (st *readState) Close() error {
+	var err error
+	if st.pending > 0 {
+		err = st.Save()
+	}
+	if cerr := st.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeJSONAtomic writes v as JSON to a temporary file and renames it over
+// path, so a crash never leaves a partial file behind.
+func writeJSONAtomic(path string, v interface{}) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(v); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(path)
+}
+
+// syncDir syncs the directory containing path, so a rename into it is durable.
+func syncDir(path string) error {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReadStateRecover(t *testing.T) {
+	tests := []struct {
+		name string
+		// archive is written before the checkpoint is loaded. offset is the
+		// checkpoint's Offset, or -1 for no checkpoint.
+		archive string
+		offset  int64
+		seen    string
+		want    []int
+		count   int
+	}{
+		{
+			name:    "new checkpoint reads whole archive",
+			archive: `{"killID":1}` + "\n" + `{"killID":2}` + "\n",
+			offset:  -1,
+			want:    []int{1, 2},
+			count:   2,
+		},
+		{
+			name:    "only past offset",
+			archive: `{"killID":1}` + "\n" + `{"killID":2}` + "\n",
+			offset:  int64(len(`{"killID":1}` + "\n")),
+			seen:    "1\n",
+			want:    []int{1, 2},
+			count:   1,
+		},
+		{
+			name:    "already in seen log",
+			archive: `{"killID":1}` + "\n" + `{"killID":2}` + "\n",
+			offset:  0,
+			seen:    "1\n2\n",
+			want:    []int{1, 2},
+			count:   2,
+		},
+		{
+			name:    "truncated archive",
+			archive: `{"killID":1}` + "\n",
+			offset:  1000,
+			seen:    "5\n",
+			want:    []int{5},
+			count:   0,
+		},
+		{
+			name:    "bad lines skipped",
+			archive: "not json\n" + `{"killID":0}` + "\n" + `{"killID":3}` + "\n",
+			offset:  -1,
+			want:    []int{3},
+			count:   1,
+		},
+		{
+			name:    "partial seen log line",
+			archive: "",
+			offset:  0,
+			seen:    "1\n2\n3",
+			want:    []int{1, 2, 3},
+			count:   0,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "zkillboard.json")
+			writeFile(t, archive, tc.archive)
+			path := statePath(archive)
+			if tc.offset >= 0 {
+				writeFile(t, path, `{"File":"`+archive+`","Offset":`+strconv.FormatInt(tc.offset, 10)+`}`)
+			}
+			if tc.seen != "" {
+				writeFile(t, seenPath(path), tc.seen)
+			}
+			st, err := loadReadState(path, 10, &fileSink{path: archive})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer st.Close()
+			if !reflect.DeepEqual(st.order, tc.want) {
+				t.Errorf("seen %v, want %v", st.order, tc.want)
+			}
+			if st.Count != tc.count {
+				t.Errorf("count %d, want %d", st.Count, tc.count)
+			}
+			if st.Offset != int64(len(tc.archive)) {
+				t.Errorf("offset %d, want %d", st.Offset, len(tc.archive))
+			}
+		})
+	}
+}
+
+func TestReadStateSeenLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zkillboard.json.state")
+	st, err := loadReadState(path, 3, stdoutSink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := 1; id <= 6; id++ {
+		if err := st.Add(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st.Has(3) || !st.Has(4) {
+		t.Errorf("expected only the last 3 killIDs, have %v", st.order)
+	}
+	// Nothing is saved until a checkpoint is due.
+	if err := st.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("checkpoint saved early: %v", err)
+	}
+	if err := st.Add(7); err != nil {
+		t.Fatal(err)
+	}
+	// 7 logged killIDs is more than twice max, so saving compacts the log.
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(seenPath(path)); string(b) != "5\n6\n7\n" {
+		t.Errorf("seen log %q", b)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "Seen") {
+		t.Errorf("checkpoint has killIDs: %s", b)
+	}
+
+	st, err = loadReadState(path, 3, stdoutSink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if st.Count != 7 || st.LastKillID != 7 || !reflect.DeepEqual(st.order, []int{5, 6, 7}) {
+		t.Errorf("reloaded count %d, last %d, seen %v", st.Count, st.LastKillID, st.order)
+	}
+}
+
+func TestReadStateMigrateSeen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "zkillboard.json.state")
+	writeFile(t, path, `{"Count":2,"Seen":[8,9]}`)
+	st, err := loadReadState(path, 10, stdoutSink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(seenPath(path)); string(b) != "8\n9\n" {
+		t.Errorf("seen log %q", b)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "Seen") {
+		t.Errorf("checkpoint still has killIDs: %s", b)
+	}
+}
+
+func writeFile(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// damageTypes are the four damage types in the order FitStats uses, as they
+// start and continue attribute names.
+var (
+	damageTypes  = [4]string{"em", "thermal", "kinetic", "explosive"}
+	damageTitles = [4]string{"Em", "Thermal", "Kinetic", "Explosive"}
+)
+
+// resistProfiles are the damage mixes EHP is computed against.
+var resistProfiles = map[string][4]float64{
+	"uniform":   {0.25, 0.25, 0.25, 0.25},
+	"em":        {1, 0, 0, 0},
+	"thermal":   {0, 1, 0, 0},
+	"kinetic":   {0, 0, 1, 0},
+	"explosive": {0, 0, 0, 1},
+}
+
+// damageControlGroup is the group of damage controls, whose resonances apply
+// to all three layers without a stacking penalty.
+const damageControlGroup = 60
+
+// FitStats are estimates of how a fit performs with all skills at V.
+type FitStats struct {
+	// DPS is the damage per second of the turrets and launchers with the
+	// charges they had loaded.
+	DPS float64
+	// EHP is the effective hit points against each of resistProfiles.
+	EHP map[string]float64
+	// Speed is the top speed in m/s with the best propulsion module active.
+	Speed float64
+	// Align is the seconds to align for warp without propulsion modules.
+	Align float64
+}
+
+// Stats estimates d's damage, tank and speed. It returns false if the ship has
+// no dogma attributes.
+//
+// The estimates use the ship's hull bonuses, the attributes generate_init.go
+// keeps and the skills every pilot trains: turret and missile damage and rate
+// of fire skills, Shield Management, Hull Upgrades, Mechanics, Navigation,
+// Acceleration Control, Evasive Maneuvering and Spaceship Command. Drones,
+// implants, rigs other than damage rigs, and modules other than damage mods,
+// resistance modules, damage controls, plates, shield extenders and
+// propulsion modules are ignored.
+func (s *SDEData) Stats(d *DBKillmail) (FitStats, bool) {
+	if _, ok := s.Items[d.Ship].Attributes["mass"]; !ok {
+		return FitStats{}, false
+	}
+	b := s.hullBonuses(d.Ship, 5)
+	ship := b.Ship()
+	var turretDamage, turretROF, missileDamage, missileROF []float64
+	var shieldResists, armorResists [4][]float64
+	var damageControl [3][4]float64
+	for i := range damageControl {
+		damageControl[i] = [4]float64{1, 1, 1, 1}
+	}
+	shieldHP, armorHP := ship["shieldCapacity"], ship["armorHP"]
+	mass := ship["mass"]
+	type propMod struct {
+		speedFactor, thrust, mass float64
+	}
+	var propMods []propMod
+	const med, lo = 1, 2
+	for r, rack := range [][8]DBItem{d.Hi, d.Med, d.Lo, d.Rig} {
+		for _, ic := range rack {
+			item, ok := s.Items[ic.ID]
+			if !ok || item.HasEffect("turretFitted") || item.HasEffect("launcherFitted") {
+				continue
+			}
+			attrs := b.Module(ic.ID)
+			if m, ok := attrs["damageMultiplier"]; ok {
+				turretDamage = append(turretDamage, m)
+				if rof, ok := attrs["speedMultiplier"]; ok {
+					turretROF = append(turretROF, rof)
+				}
+			}
+			if m, ok := attrs["missileDamageMultiplierBonus"]; ok {
+				missileDamage = append(missileDamage, m)
+				if rof, ok := attrs["speedMultiplier"]; ok {
+					missileROF = append(missileROF, rof)
+				}
+			}
+			if item.Group == damageControlGroup {
+				for i := range damageTypes {
+					damageControl[0][i] *= resonance(attrs, "shield"+damageTitles[i]+"DamageResonance")
+					damageControl[1][i] *= resonance(attrs, "armor"+damageTitles[i]+"DamageResonance")
+					damageControl[2][i] *= resonance(attrs, damageTypes[i]+"DamageResonance")
+				}
+				continue
+			}
+			// Resistance modules tank the shield from mid slots and the armor
+			// from low slots.
+			for i, t := range damageTypes {
+				v, ok := attrs[t+"DamageResistanceBonus"]
+				if !ok {
+					continue
+				}
+				switch r {
+				case med:
+					shieldResists[i] = append(shieldResists[i], 1+v/100)
+				case lo:
+					armorResists[i] = append(armorResists[i], 1+v/100)
+				}
+			}
+			shieldHP += attrs["capacityBonus"]
+			armorHP += attrs["armorHPBonusAdd"]
+			if sf, ok := attrs["speedFactor"]; ok && attrs["speedBoostFactor"] > 0 {
+				propMods = append(propMods, propMod{sf, attrs["speedBoostFactor"], attrs["massAddition"]})
+			} else {
+				// Plates.
+				mass += attrs["massAddition"]
+			}
+		}
+	}
+
+	var stats FitStats
+	turretMult := stackingPenalized(turretDamage) * 1.25 * 1.15
+	turretTime := stackingPenalized(turretROF) * 0.9 * 0.8
+	missileMult := stackingPenalized(missileDamage) * 1.25 * 1.10
+	missileTime := stackingPenalized(missileROF) * 0.9 * 0.85
+	for _, ic := range d.Hi {
+		item, ok := s.Items[ic.ID]
+		if !ok || ic.Charge == 0 {
+			continue
+		}
+		attrs, charge := b.Module(ic.ID), b.Charge(ic.Charge)
+		var damage float64
+		for _, t := range damageTypes {
+			damage += charge[t+"Damage"]
+		}
+		cycle := attrs["speed"] / 1000
+		switch {
+		case item.HasEffect("turretFitted"):
+			damage *= attrs["damageMultiplier"] * turretMult
+			cycle *= turretTime
+		case item.HasEffect("launcherFitted"):
+			damage *= missileMult
+			cycle *= missileTime
+		default:
+			continue
+		}
+		if cycle > 0 {
+			stats.DPS += damage / cycle
+		}
+	}
+
+	layers := [3]struct {
+		hp        float64
+		resonance [4]float64
+	}{
+		{hp: shieldHP * 1.25},
+		{hp: armorHP * 1.25},
+		{hp: ship["hp"] * 1.25},
+	}
+	for i := range damageTypes {
+		layers[0].resonance[i] = resonance(ship, "shield"+damageTitles[i]+"DamageResonance") * stackingPenalized(shieldResists[i]) * damageControl[0][i]
+		layers[1].resonance[i] = resonance(ship, "armor"+damageTitles[i]+"DamageResonance") * stackingPenalized(armorResists[i]) * damageControl[1][i]
+		layers[2].resonance[i] = resonance(ship, damageTypes[i]+"DamageResonance") * damageControl[2][i]
+	}
+	stats.EHP = map[string]float64{}
+	for name, profile := range resistProfiles {
+		var ehp float64
+		for _, l := range layers {
+			var taken float64
+			for i, p := range profile {
+				taken += p * l.resonance[i]
+			}
+			if taken > 0 {
+				ehp += l.hp / taken
+			}
+		}
+		stats.EHP[name] = ehp
+	}
+
+	speed := ship["maxVelocity"] * 1.25
+	stats.Speed = speed
+	for _, p := range propMods {
+		v := speed * (1 + p.speedFactor*1.25/100*p.thrust/(mass+p.mass))
+		if v > stats.Speed {
+			stats.Speed = v
+		}
+	}
+	stats.Align = math.Log(4) * mass * ship["agility"] * 0.75 * 0.9 / 1e6
+	return stats, true
+}
+
+// resonance is a resonance attribute, which is 1 (no resistance) if the item
+// doesn't have it.
+func resonance(attrs map[string]float64, name string) float64 {
+	if v, ok := attrs[name]; ok {
+		return v
+	}
+	return 1
+}
+
+// stackingPenalized multiplies mults together the way dogma does for modules
+// changing the same attribute: the strongest counts fully and each next one
+// less. Bonuses and penalties are penalized separately.
+func stackingPenalized(mults []float64) float64 {
+	var bonuses, penalties []float64
+	for _, m := range mults {
+		if m > 1 {
+			bonuses = append(bonuses, m)
+		} else {
+			penalties = append(penalties, m)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.Float64Slice(bonuses)))
+	sort.Float64s(penalties)
+	total := 1.0
+	for _, group := range [][]float64{bonuses, penalties} {
+		for i, m := range group {
+			total *= 1 + (m-1)*math.Exp(-math.Pow(float64(i)/2.67, 2))
+		}
+	}
+	return total
+}
@@ -0,0 +1,79 @@
+package main
+
+import "testing"
+
+func TestStackingPenalized(t *testing.T) {
+	tests := []struct {
+		mults []float64
+		want  float64
+	}{
+		{nil, 1},
+		{[]float64{1.1}, 1.1},
+		{[]float64{1.1, 1.1}, 1.195603197888044},
+		{[]float64{1.1, 1.1, 1.1}, 1.263822300992268},
+		// Penalties sort the strongest first too.
+		{[]float64{0.9, 0.8}, 0.7304704015359683},
+		// Bonuses and penalties are penalized separately.
+		{[]float64{1.1, 0.9}, 0.99},
+	}
+	for _, tc := range tests {
+		if got := stackingPenalized(tc.mults); !closeTo(got, tc.want) {
+			t.Errorf("%v: got %v, want %v", tc.mults, got, tc.want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	const gunnery = 3300
+	s := &SDEData{
+		Items: map[int]Item{
+			1: {ID: 1, Group: 25, Attributes: map[string]float64{
+				"mass": 1e6, "agility": 3, "maxVelocity": 300,
+				"shieldCapacity": 400, "armorHP": 400, "hp": 300,
+			}, Modifiers: []Modifier{
+				{Target: "module", Skill: gunnery, Attribute: "damageMultiplier", Operation: 6, Value: 5, PerLevel: true},
+				{Target: "ship", Attribute: "maxVelocity", Operation: 6, Value: 5, PerLevel: true},
+			}},
+			10: {ID: 10, Group: 55, Effects: []string{"turretFitted"}, Attributes: map[string]float64{
+				"damageMultiplier": 2, "speed": 2000, "requiredSkill1": gunnery,
+			}},
+			12: {ID: 12, Group: 59, Attributes: map[string]float64{"damageMultiplier": 1.1, "speedMultiplier": 0.9}},
+			13: {ID: 13, Group: 46, Attributes: map[string]float64{"speedFactor": 100, "speedBoostFactor": 1e6, "massAddition": 5e5}},
+			30: {ID: 30, Group: 83, Attributes: map[string]float64{"emDamage": 10, "explosiveDamage": 2}},
+		},
+	}
+	d := testFit(1, []int{10, 10}, []int{13}, nil)
+	d.Hi[0].Charge = 30
+	d.Hi[1].Charge = 30
+	d.Lo[0].ID = 12
+
+	got, ok := s.Stats(d)
+	if !ok {
+		t.Fatal("no stats")
+	}
+	// Each turret does 12 damage, times 2 for the turret, 1.25 for the hull,
+	// 1.25 and 1.15 for skills and 1.1 for the damage mod, every 2s times 0.9
+	// and 0.8 for skills and 0.9 for the damage mod.
+	if !closeTo(got.DPS, 73.20601851851849) {
+		t.Errorf("DPS %v", got.DPS)
+	}
+	for profile, ehp := range got.EHP {
+		if !closeTo(ehp, 1375) {
+			t.Errorf("%s EHP %v", profile, ehp)
+		}
+	}
+	if len(got.EHP) != len(resistProfiles) {
+		t.Errorf("EHP profiles %v", got.EHP)
+	}
+	// The hull and Navigation bonuses, then the afterburner.
+	if !closeTo(got.Speed, 859.375) {
+		t.Errorf("speed %v", got.Speed)
+	}
+	if !closeTo(got.Align, 2.8072460812677784) {
+		t.Errorf("align %v", got.Align)
+	}
+
+	if _, ok := s.Stats(&DBKillmail{Ship: 99}); ok {
+		t.Error("stats for a ship without attributes")
+	}
+}
@@ -12,10 +12,14 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -28,67 +32,264 @@ var GROUPS_JSON []byte
 //go:embed items.json
 var ITEMS_JSON []byte
 
+//go:embed universe.json
+var UNIVERSE_JSON []byte
+
+//go:embed mutations.json
+var MUTATIONS_JSON []byte
+
+//go:embed marketgroups.json
+var MARKETGROUPS_JSON []byte
+
 type WebContext struct {
 	DB           *sql.DB
 	X            *sqlx.DB
-	Data         SDEData
+	Names        Names
 	enableTiming bool
 
 	lock        sync.RWMutex
 	lastQueryID int64
 	queries     map[string]int64
+
+	// data holds a *SDEData, replaced when SDE_Path is reloaded.
+	data atomic.Value
+
+	marketLock sync.Mutex
+	market     *marketCounts
+}
+
+// marketCountsTTL is how long Browse uses the same marketCounts.
+const marketCountsTTL = time.Minute
+
+// marketCounts are item_counts and their sums for each market group of data,
+// so Browse doesn't have to send every item below a group to the database.
+type marketCounts struct {
+	data    *SDEData
+	fetched time.Time
+	items   map[int]int
+	groups  map[int]int
 }
 
-func (d *DBKillmail) toFK(s *SDEData) FittingsKillmail {
+// browseCounts returns the market counts for data, fetching item_counts again
+// if they are older than marketCountsTTL.
+func (s *WebContext) browseCounts(ctx context.Context, data *SDEData) (*marketCounts, error) {
+	s.marketLock.Lock()
+	defer s.marketLock.Unlock()
+	if m := s.market; m != nil && m.data == data && time.Since(m.fetched) < marketCountsTTL {
+		return m, nil
+	}
+	m := &marketCounts{data: data, fetched: time.Now(), items: map[int]int{}}
+	rows, err := s.DB.QueryContext(ctx, `SELECT item, count FROM item_counts`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item, count int
+		if err := rows.Scan(&item, &count); err != nil {
+			return nil, err
+		}
+		m.items[item] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	m.groups = data.MarketGroupFits(m.items)
+	s.market = m
+	return m, nil
+}
+
+// Data returns the current SDE. Handlers should call it once so they use the
+// same SDE throughout a request.
+func (s *WebContext) Data() *SDEData {
+	return s.data.Load().(*SDEData)
+}
+
+// sdePollInterval is how often web checks SDE_Path for changes.
+const sdePollInterval = 10 * time.Second
+
+// watchSDE reloads the SDE from dir on SIGHUP or when its files change after
+// loaded, the modification time from before the current SDE was read. A
+// reload that fails keeps the current SDE.
+func (s *WebContext) watchSDE(dir string, loaded time.Time) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tick := time.NewTicker(sdePollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-hup:
+		case <-tick.C:
+			if !sdeModTime(dir).After(loaded) {
+				continue
+			}
+		}
+		modTime := sdeModTime(dir)
+		data, err := LoadSDEData(dir)
+		if err != nil {
+			log.Printf("reloading SDE from %s: %v", dir, err)
+			continue
+		}
+		s.data.Store(&data)
+		loaded = modTime
+		log.Printf("reloaded SDE from %s: %d items", dir, len(data.Items))
+	}
+}
+
+func (d *DBKillmail) toFK(s *SDEData, names Names) FittingsKillmail {
 	f := FittingsKillmail{
-		ID:     d.ID,
-		Cost:   d.Cost,
-		Ship:   s.Items[d.Ship],
-		Charge: []Item{},
+		ID:             d.ID,
+		Cost:           d.Cost,
+		DestroyedValue: d.DestroyedValue,
+		DroppedValue:   d.DroppedValue,
+		TotalValue:     d.TotalValue,
+		Time:           d.Time,
+		System:         s.NamedSystem(d.System),
+		Location:       d.Location,
+		Labels:         d.Labels,
+		Solo:           d.Solo,
+		NPC:            d.NPC,
+		Awox:           d.Awox,
+		Overfit:        d.Overfit,
+		Stats:          d.Stats,
+		Victim:         names.NamedPilot(d.Victim),
+		Ship:           s.NamedItem(d.Ship),
+		Charge:         []NamedItem{},
 	}
 	f.Hi = fromDBItem(s, d.Hi)
 	f.Med = fromDBItem(s, d.Med)
 	f.Lo = fromDBItem(s, d.Lo)
 	f.Rig = fromDBItem(s, d.Rig)
 	f.Sub = fromDBItem(s, d.Sub)
-	for _, c := range d.QueryItems {
-		item := s.Items[c]
-		if s.Groups[item.Group].IsCharge() {
-			f.Charge = append(f.Charge, item)
+	f.Service = fromDBItem(s, d.Service)
+	f.Slots = s.SlotLayout(d)
+	f.Drone = fromDBQuantity(s, d.Drone)
+	f.Fighter = fromDBQuantity(s, d.Fighter)
+	f.Cargo = fromDBQuantity(s, d.Cargo)
+	for _, id := range d.Implant {
+		f.Implant = append(f.Implant, s.NamedItem(id))
+	}
+	for _, id := range d.Booster {
+		f.Booster = append(f.Booster, s.NamedItem(id))
+	}
+	f.Ammo = fromDBAmmo(s, d)
+	for _, a := range f.Ammo {
+		// Only loaded charges are in QueryItems.
+		if containsInt(d.QueryItems, a.ID) {
+			f.Charge = append(f.Charge, a.NamedItem)
 		}
 	}
 	return f
 }
 
+// fromDBAmmo totals the charges loaded in d's modules and carried in its cargo.
+// Fits processed before ChargeQty was recorded have loaded charges with a zero
+// Loaded count.
+func fromDBAmmo(s *SDEData, d *DBKillmail) []ItemAmmo {
+	ammo := map[int]*ItemAmmo{}
+	get := func(id int) *ItemAmmo {
+		a := ammo[id]
+		if a == nil {
+			a = &ItemAmmo{NamedItem: s.NamedItem(id)}
+			ammo[id] = a
+		}
+		return a
+	}
+	for _, rack := range [][8]DBItem{d.Hi, d.Med, d.Lo, d.Rig, d.Sub, d.Service} {
+		for _, ic := range rack {
+			if ic.Charge != 0 {
+				get(ic.Charge).Loaded += ic.ChargeQty
+			}
+		}
+	}
+	for _, q := range d.Cargo {
+		if s.Groups[s.Items[q.ID].Group].IsCharge() {
+			get(q.ID).Cargo += q.Quantity
+		}
+	}
+	if len(ammo) == 0 {
+		return nil
+	}
+	ret := make([]ItemAmmo, 0, len(ammo))
+	for _, a := range ammo {
+		ret = append(ret, *a)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
 func fromDBItem(s *SDEData, c [8]DBItem) [8]ItemCharge {
 	var d [8]ItemCharge
 	for i, ic := range c {
 		d[i].ID = ic.ID
-		d[i].Name = s.Items[ic.ID].Name
+		d[i].Name = s.NamedItem(ic.ID).Name
+		d[i].Dropped = ic.Dropped
 		if ic.Charge != 0 {
 			item := s.NamedItem(ic.Charge)
 			d[i].Charge = &item
+			d[i].ChargeQty = ic.ChargeQty
+		}
+		if m, ok := s.Mutations[ic.ID]; ic.Mutated && ok {
+			var mut ItemMutation
+			for _, id := range m.Mutaplasmids {
+				mut.Mutaplasmids = append(mut.Mutaplasmids, s.NamedItem(id))
+			}
+			for _, id := range m.Sources {
+				mut.Sources = append(mut.Sources, s.NamedItem(id))
+			}
+			d[i].Mutation = &mut
 		}
 	}
 	return d
 }
 
-func web(port string, dbURL string) {
+func fromDBQuantity(s *SDEData, c []DBQuantity) []ItemQuantity {
+	if len(c) == 0 {
+		return nil
+	}
+	d := make([]ItemQuantity, len(c))
+	for i, q := range c {
+		// Keep the ID of types that aren't in the SDE data; they have no name.
+		d[i].NamedItem = NamedItem{ID: q.ID, Name: s.NamedItem(q.ID).Name}
+		d[i].Quantity = q.Quantity
+	}
+	return d
+}
+
+func web(port string, dbURL string, namesPath string, sdePath string) {
 	db := init_sql(dbURL)
 	defer db.Close()
+	names, err := loadNames(namesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Files changed while the SDE is being read are picked up by watchSDE.
+	modTime := sdeModTime(sdePath)
+	data, err := LoadSDEData(sdePath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	s := &WebContext{
 		DB:           db,
 		X:            sqlx.NewDb(db, "postgres"),
-		Data:         MakeSDEData(),
+		Names:        names,
 		enableTiming: true,
 		queries:      make(map[string]int64),
 	}
+	s.data.Store(&data)
+	if sdePath != "" {
+		go s.watchSDE(sdePath, modTime)
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/api/Fit", s.Wrap(s.Fit))
 	mux.Handle("/api/Fits", s.Wrap(s.Fits))
 	mux.Handle("/api/Search", s.Wrap(s.Search))
+	mux.Handle("/api/Counters", s.Wrap(s.Counters))
+	mux.Handle("/api/Loot", s.Wrap(s.Loot))
+	mux.Handle("/api/Ammo", s.Wrap(s.Ammo))
+	mux.Handle("/api/Browse", s.Wrap(s.Browse))
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if err := s.DB.Ping(); err != nil {
 			http.Error(w, err.Error(), 500)
@@ -152,6 +353,7 @@ func (s *WebContext) Wrap(
 func (s *WebContext) Fit(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
+	data := s.Data()
 	id := r.FormValue("id")
 	if id == "" {
 		return nil, errors.New("missing fit id")
@@ -164,23 +366,41 @@ func (s *WebContext) Fit(
 	if err := json.Unmarshal(raw, &dbkm); err != nil {
 		return nil, err
 	}
-	return dbkm.toFK(&s.Data), nil
+	fk := dbkm.toFK(data, s.Names)
+	for _, a := range dbkm.Attackers {
+		fk.Attackers = append(fk.Attackers, s.Names.NamedPilot(a))
+	}
+	if fitting, ok := data.Fitting(&dbkm); ok {
+		fk.Fitting = &fitting
+	}
+	return fk, nil
 }
 
 func (s *WebContext) Fits(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
+	data := s.Data()
 	var ret struct {
-		Filter map[string][]Item
-		Fits   []FittingsKillmail
+		Filter  map[string][]NamedItem
+		After   string   `json:",omitempty"`
+		Before  string   `json:",omitempty"`
+		Solo    *bool    `json:",omitempty"`
+		NPC     *bool    `json:",omitempty"`
+		Awox    *bool    `json:",omitempty"`
+		Sec     string   `json:",omitempty"`
+		Labels  []string `json:",omitempty"`
+		Mutated bool     `json:",omitempty"`
+		Overfit *bool    `json:",omitempty"`
+		Sort    string   `json:",omitempty"`
+		Fits    []FittingsKillmail
 	}
-	ret.Filter = map[string][]Item{}
+	ret.Filter = map[string][]NamedItem{}
 	r.ParseForm()
 
 	items := map[int]struct{}{}
 	if ship, _ := strconv.Atoi(r.Form.Get("ship")); ship > 0 {
 		items[ship] = struct{}{}
-		ret.Filter["ship"] = append(ret.Filter["ship"], s.Data.Items[ship])
+		ret.Filter["ship"] = append(ret.Filter["ship"], data.NamedItem(ship))
 	}
 	for _, item := range r.Form["item"] {
 		itemid, _ := strconv.Atoi(item)
@@ -188,16 +408,169 @@ func (s *WebContext) Fits(
 			continue
 		}
 		items[itemid] = struct{}{}
-		ret.Filter["item"] = append(ret.Filter["item"], s.Data.Items[itemid])
+		ret.Filter["item"] = append(ret.Filter["item"], data.NamedItem(itemid))
+	}
+
+	var filter fitsFilter
+	for _, param := range []struct {
+		name string
+		dst  *string
+	}{
+		{"after", &filter.After},
+		{"before", &filter.Before},
+	} {
+		v := r.Form.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := parseFilterTime(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", param.name, err)
+		}
+		*param.dst = t.Format(time.RFC3339)
+	}
+	systems := map[int]struct{}{}
+	for _, v := range r.Form["system"] {
+		id, _ := strconv.Atoi(v)
+		if sys, ok := data.Systems[id]; ok {
+			systems[id] = struct{}{}
+			ret.Filter["system"] = append(ret.Filter["system"], NamedItem{ID: id, Name: sys.Name})
+		}
+	}
+	for _, v := range r.Form["constellation"] {
+		id, _ := strconv.Atoi(v)
+		if c, ok := data.Constellations[id]; ok {
+			for _, sys := range data.Systems {
+				if sys.Constellation == id {
+					systems[sys.ID] = struct{}{}
+				}
+			}
+			ret.Filter["constellation"] = append(ret.Filter["constellation"], NamedItem{ID: id, Name: c.Name})
+		}
+	}
+	for _, v := range r.Form["region"] {
+		id, _ := strconv.Atoi(v)
+		if reg, ok := data.Regions[id]; ok {
+			for _, sys := range data.Systems {
+				if sys.Region == id {
+					systems[sys.ID] = struct{}{}
+				}
+			}
+			ret.Filter["region"] = append(ret.Filter["region"], NamedItem{ID: id, Name: reg.Name})
+		}
+	}
+	for id := range systems {
+		filter.Systems = append(filter.Systems, id)
+	}
+	for _, param := range []struct {
+		name string
+		dst  *[]int
+	}{
+		{"character", &filter.Characters},
+		{"corporation", &filter.Corporations},
+		{"alliance", &filter.Alliances},
+	} {
+		for _, v := range r.Form[param.name] {
+			id, _ := strconv.Atoi(v)
+			if id <= 0 || containsInt(*param.dst, id) {
+				continue
+			}
+			*param.dst = append(*param.dst, id)
+			ret.Filter[param.name] = append(ret.Filter[param.name], NamedItem{ID: id, Name: s.Names[id]})
+		}
+		sort.Ints(*param.dst)
+	}
+	sort.Ints(filter.Systems)
+	for _, param := range []struct {
+		name string
+		dst  **bool
+	}{
+		{"solo", &filter.Solo},
+		{"npc", &filter.NPC},
+		{"awox", &filter.Awox},
+	} {
+		v := r.Form.Get(param.name)
+		if v == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", param.name, err)
+		}
+		*param.dst = &b
+	}
+	if sec := r.Form.Get("sec"); sec != "" {
+		if !secBands[sec] {
+			return nil, fmt.Errorf("unknown sec %q", sec)
+		}
+		filter.Sec = sec
+	}
+	for _, label := range r.Form["label"] {
+		if label != "" && !containsString(filter.Labels, label) {
+			filter.Labels = append(filter.Labels, label)
+		}
+	}
+	sort.Strings(filter.Labels)
+	if v := r.Form.Get("mutated"); v != "" && len(items) > 0 {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("mutated: %w", err)
+		}
+		filter.Mutated = b
+	}
+	ret.After = filter.After
+	ret.Before = filter.Before
+	ret.Solo = filter.Solo
+	ret.NPC = filter.NPC
+	ret.Awox = filter.Awox
+	ret.Sec = filter.Sec
+	ret.Labels = filter.Labels
+	ret.Mutated = filter.Mutated
+	if v := r.Form.Get("overfit"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("overfit: %w", err)
+		}
+		filter.Overfit = &b
+	}
+	ret.Overfit = filter.Overfit
+	for _, f := range fitsStats {
+		for _, param := range []string{"min_" + f.name, "max_" + f.name} {
+			v := r.Form.Get(param)
+			if v == "" {
+				continue
+			}
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", param, err)
+			}
+			if filter.Stats == nil {
+				filter.Stats = map[string]float64{}
+			}
+			filter.Stats[param] = n
+		}
+	}
+	var sortBy *fitsStat
+	if v := r.Form.Get("sort"); v != "" {
+		for i := range fitsStats {
+			if fitsStats[i].name == v {
+				sortBy = &fitsStats[i]
+			}
+		}
+		if sortBy == nil {
+			return nil, fmt.Errorf("unknown sort %q", v)
+		}
+		filter.Sort = v
+		ret.Sort = v
 	}
 
 	var query strings.Builder
 	query.WriteString(`SELECT data FROM killmail_results`)
 	var args []interface{}
-	if len(items) == 0 {
+	if len(items) == 0 && filter.empty() {
 		query.WriteString("_root")
 	} else {
-		queryID, err := s.QueryID(ctx, items)
+		queryID, err := s.QueryID(ctx, items, filter)
 		if err != nil {
 			return nil, err
 		}
@@ -215,25 +588,114 @@ func (s *WebContext) Fits(
 	}
 	defer rows.Close()
 	ret.Fits = make([]FittingsKillmail, 0)
-	var dbkm DBKillmail
 	var raw json.RawMessage
 	for rows.Next() {
 		if err := rows.Scan(&raw); err != nil {
 			return nil, err
 		}
+		// A new DBKillmail each time, since Unmarshal leaves fields that
+		// aren't in raw alone.
+		var dbkm DBKillmail
 		if err := json.Unmarshal(raw, &dbkm); err != nil {
 			return nil, err
 		}
-		fk := dbkm.toFK(&s.Data)
+		fk := dbkm.toFK(data, s.Names)
 		ret.Fits = append(ret.Fits, fk)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	// The results view picked the top fits by the stat, but doesn't order them.
+	if sortBy != nil {
+		sort.SliceStable(ret.Fits, func(i, j int) bool {
+			a, b := ret.Fits[i].Stats, ret.Fits[j].Stats
+			if a == nil || b == nil {
+				return b == nil && a != nil
+			}
+			if sortBy.ascending {
+				return sortBy.value(a) < sortBy.value(b)
+			}
+			return sortBy.value(a) > sortBy.value(b)
+		})
+	}
 	return ret, nil
 }
 
-func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}) (int64, error) {
+// fitsStat is a FitStats value /api/Fits can filter with min_<name> and
+// max_<name> and sort by. The query_filtered view has a column for each.
+type fitsStat struct {
+	name  string
+	value func(*FitStats) float64
+	// ascending sorts the smallest first, for stats where less is better.
+	ascending bool
+}
+
+var fitsStats = []fitsStat{
+	{name: "dps", value: func(s *FitStats) float64 { return s.DPS }},
+	{name: "ehp", value: func(s *FitStats) float64 { return s.EHP["uniform"] }},
+	{name: "speed", value: func(s *FitStats) float64 { return s.Speed }},
+	{name: "align", value: func(s *FitStats) float64 { return s.Align }, ascending: true},
+}
+
+// fitsFilter narrows a query beyond the items its fits must have. It's stored
+// with the query and applied by the query_filtered view, so its JSON keys must
+// match the ones used there.
+type fitsFilter struct {
+	After   string   `json:"after,omitempty"`
+	Before  string   `json:"before,omitempty"`
+	Systems []int    `json:"systems,omitempty"`
+	Solo    *bool    `json:"solo,omitempty"`
+	NPC     *bool    `json:"npc,omitempty"`
+	Awox    *bool    `json:"awox,omitempty"`
+	Sec     string   `json:"sec,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+	// Victim filters.
+	Characters   []int `json:"characters,omitempty"`
+	Corporations []int `json:"corporations,omitempty"`
+	Alliances    []int `json:"alliances,omitempty"`
+	// Mutated also matches items with fits that have a mutated version of them,
+	// for at most one of the queried items. It's used by the query_fits view.
+	Mutated bool `json:"mutated,omitempty"`
+	// Overfit matches fits whose Overfit is the same.
+	Overfit *bool `json:"overfit,omitempty"`
+	// Stats are the min_<stat> and max_<stat> bounds of fitsStats, and Sort
+	// is the stat results are the top of instead of the newest.
+	Stats map[string]float64 `json:"stats,omitempty"`
+	Sort  string             `json:"sort,omitempty"`
+}
+
+func (f fitsFilter) empty() bool {
+	b, _ := json.Marshal(f)
+	return string(b) == "{}"
+}
+
+// secBands are the zKillboard location labels accepted by the sec filter.
+var secBands = map[string]bool{
+	"highsec": true,
+	"lowsec":  true,
+	"nullsec": true,
+	"w-space": true,
+	"abyssal": true,
+	"pochven": true,
+}
+
+func containsString(list []string, v string) bool {
+	for _, l := range list {
+		if l == v {
+			return true
+		}
+	}
+	return false
+}
+
+func parseFilterTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}, filter fitsFilter) (int64, error) {
 	// Dedup and sort to make canonical.
 	items := make([]int, 0, len(itemMap))
 	for item := range itemMap {
@@ -244,7 +706,13 @@ func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}) (int
 	if err != nil {
 		return 0, err
 	}
-	name := string(marshaled)
+	itemsJSON := string(marshaled)
+	marshaled, err = json.Marshal(filter)
+	if err != nil {
+		return 0, err
+	}
+	filterJSON := string(marshaled)
+	name := itemsJSON + filterJSON
 
 	s.lock.RLock()
 	query_id := s.queries[name]
@@ -262,7 +730,7 @@ func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}) (int
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if err := s.DB.QueryRowContext(ctx, "SELECT id FROM queries WHERE items = $1", name).Scan(&query_id); err == nil {
+	if err := s.DB.QueryRowContext(ctx, "SELECT id FROM queries WHERE items = $1 AND filter = $2", itemsJSON, filterJSON).Scan(&query_id); err == nil {
 		// This query already has an id, use it.
 		s.queries[name] = query_id
 		return query_id, nil
@@ -281,7 +749,7 @@ func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}) (int
 			return 0, err
 		}
 		// TODO: add timing to see how long it takes for results to pop out.
-		if _, err := s.DB.ExecContext(ctx, "INSERT INTO queries VALUES ($1, $2)", s.lastQueryID, name); err != nil {
+		if _, err := s.DB.ExecContext(ctx, "INSERT INTO queries VALUES ($1, $2, $3)", s.lastQueryID, itemsJSON, filterJSON); err != nil {
 			return 0, err
 		}
 		// We don't need to listen for updates because, since we used a table, any
@@ -291,16 +759,240 @@ func (s *WebContext) QueryID(ctx context.Context, itemMap map[int]struct{}) (int
 	}
 }
 
+// Counters returns the attacker ships and weapons that most often appear on
+// losses of a ship, or of fits with an item.
+func (s *WebContext) Counters(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	data := s.Data()
+	type Counter struct {
+		NamedItem
+		Count int
+	}
+	var ret struct {
+		Item    NamedItem
+		Losses  int
+		Ships   []Counter
+		Weapons []Counter
+	}
+	id, _ := strconv.Atoi(r.FormValue("ship"))
+	if id <= 0 {
+		id, _ = strconv.Atoi(r.FormValue("item"))
+	}
+	if id <= 0 {
+		return nil, errors.New("missing ship or item")
+	}
+	ret.Item = data.NamedItem(id)
+	if err := s.DB.QueryRowContext(ctx, `SELECT count FROM item_counts WHERE item = $1`, id).Scan(&ret.Losses); err == sql.ErrNoRows {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, q := range []struct {
+		query string
+		dst   *[]Counter
+	}{
+		{`SELECT ship, count FROM counter_ships WHERE item = $1 ORDER BY count DESC LIMIT 50`, &ret.Ships},
+		{`SELECT weapon, count FROM counter_weapons WHERE item = $1 ORDER BY count DESC LIMIT 50`, &ret.Weapons},
+	} {
+		rows, err := s.DB.QueryContext(ctx, q.query, id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var c Counter
+			if err := rows.Scan(&c.ID, &c.Count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			c.Name = data.Items[c.ID].Name
+			*q.dst = append(*q.dst, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Loot reports how much of a ship or item survives its losses. For modules
+// it's how often the module itself dropped; for both it's how much ISK the
+// rest of the losses dropped.
+func (s *WebContext) Loot(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	data := s.Data()
+	var ret struct {
+		Item           NamedItem
+		Losses         int
+		DroppedValue   int64
+		DestroyedValue int64
+		// AvgDropped is the mean DroppedValue of a loss.
+		AvgDropped float64
+		// Fitted is how many fitted modules of this type were on losses, and
+		// Dropped how many of those survived.
+		Fitted   int     `json:",omitempty"`
+		Dropped  int     `json:",omitempty"`
+		DropRate float64 `json:",omitempty"`
+	}
+	id, _ := strconv.Atoi(r.FormValue("ship"))
+	if id <= 0 {
+		id, _ = strconv.Atoi(r.FormValue("item"))
+	}
+	if id <= 0 {
+		return nil, errors.New("missing ship or item")
+	}
+	ret.Item = data.NamedItem(id)
+	if err := s.DB.QueryRowContext(ctx,
+		`SELECT count, dropped_value, destroyed_value FROM item_loot WHERE item = $1`, id,
+	).Scan(&ret.Losses, &ret.DroppedValue, &ret.DestroyedValue); err == sql.ErrNoRows {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ret.Losses > 0 {
+		ret.AvgDropped = float64(ret.DroppedValue) / float64(ret.Losses)
+	}
+	if err := s.DB.QueryRowContext(ctx,
+		`SELECT count, dropped FROM module_drops WHERE item = $1`, id,
+	).Scan(&ret.Fitted, &ret.Dropped); err == sql.ErrNoRows {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ret.Fitted > 0 {
+		ret.DropRate = float64(ret.Dropped) / float64(ret.Fitted)
+	}
+	return ret, nil
+}
+
+// Ammo reports which charges are loaded in a module across all fits.
+func (s *WebContext) Ammo(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	data := s.Data()
+	type Charge struct {
+		NamedItem
+		// Count is how many of the modules had this charge loaded.
+		Count    int
+		Quantity int
+		Share    float64
+	}
+	var ret struct {
+		Item    NamedItem
+		Loaded  int
+		Charges []Charge
+	}
+	id, _ := strconv.Atoi(r.FormValue("item"))
+	if id <= 0 {
+		return nil, errors.New("missing item")
+	}
+	ret.Item = data.NamedItem(id)
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT charge, count, quantity FROM module_charges WHERE item = $1 ORDER BY count DESC`, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c Charge
+		if err := rows.Scan(&c.ID, &c.Count, &c.Quantity); err != nil {
+			return nil, err
+		}
+		c.Name = data.NamedItem(c.ID).Name
+		ret.Loaded += c.Count
+		ret.Charges = append(ret.Charges, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range ret.Charges {
+		ret.Charges[i].Share = float64(ret.Charges[i].Count) / float64(ret.Loaded)
+	}
+	return ret, nil
+}
+
+// Browse walks the market group tree, returning the groups and items directly
+// in a market group, or the root groups without one. Only groups with ships or
+// items below them are included.
+func (s *WebContext) Browse(
+	ctx context.Context, r *http.Request, timing *servertiming.Header,
+) (interface{}, error) {
+	data := s.Data()
+	type Group struct {
+		MarketGroup
+		// ItemFits is the sum of the Fits of the items below the group, so a
+		// fit with several of them is counted once for each.
+		ItemFits int
+	}
+	type BrowseItem struct {
+		// Type is "ship" or "item", as in Search.
+		Type string
+		Name string
+		ID   int
+		// Fits is the number of fits with the item, from item_counts.
+		Fits int
+	}
+	var ret struct {
+		Group *MarketGroup `json:",omitempty"`
+		// Path is Group's ancestors, starting at the root.
+		Path   []MarketGroup
+		Groups []Group
+		Items  []BrowseItem
+	}
+	id, _ := strconv.Atoi(r.FormValue("marketGroup"))
+	if id != 0 {
+		g, ok := data.MarketGroups[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown market group %d", id)
+		}
+		ret.Group = &g
+		for p := g.Parent; p != 0; p = data.MarketGroups[p].Parent {
+			ret.Path = append([]MarketGroup{data.MarketGroups[p]}, ret.Path...)
+		}
+	}
+
+	counts, err := s.browseCounts(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range data.marketChildren[id] {
+		ret.Groups = append(ret.Groups, Group{
+			MarketGroup: data.MarketGroups[child],
+			ItemFits:    counts.groups[child],
+		})
+	}
+	for _, item := range data.marketItems[id] {
+		ret.Items = append(ret.Items, BrowseItem{
+			Type: searchCategories[data.Groups[data.Items[item].Group].Category],
+			Name: data.Items[item].Name,
+			ID:   item,
+			Fits: counts.items[item],
+		})
+	}
+	sort.Slice(ret.Groups, func(i, j int) bool { return ret.Groups[i].Name < ret.Groups[j].Name })
+	sort.Slice(ret.Items, func(i, j int) bool { return ret.Items[i].Name < ret.Items[j].Name })
+	return ret, nil
+}
+
 var searchCategories = map[int]string{
 	6:  "ship",
 	7:  "item", // module
 	8:  "item", // charge
+	18: "item", // drone
+	20: "item", // implant
 	32: "item", // subsystem
+	65: "ship", // structure
+	66: "item", // structure module
+	87: "item", // fighter
 }
 
 func (s *WebContext) Search(
 	ctx context.Context, r *http.Request, timing *servertiming.Header,
 ) (interface{}, error) {
+	data := s.Data()
 	type Result struct {
 		Type string
 		Name string
@@ -328,7 +1020,7 @@ func (s *WebContext) Search(
 		}
 		return containsAll
 	}
-	for id, group := range s.Data.Groups {
+	for id, group := range data.Groups {
 		if !match(group.Lower) {
 			continue
 		}
@@ -338,11 +1030,11 @@ func (s *WebContext) Search(
 			ID:   id,
 		})
 	}
-	for id, item := range s.Data.Items {
+	for id, item := range data.Items {
 		if !match(item.Lower) {
 			continue
 		}
-		if typ := searchCategories[s.Data.Groups[item.Group].Category]; typ != "" {
+		if typ := searchCategories[data.Groups[item.Group].Category]; typ != "" {
 			ret.Results = append(ret.Results, Result{
 				Type: typ,
 				Name: item.Name,
@@ -353,6 +1045,30 @@ func (s *WebContext) Search(
 			break
 		}
 	}
+	for id, region := range data.Regions {
+		if len(ret.Results) > 50 {
+			break
+		}
+		if match(region.Lower) {
+			ret.Results = append(ret.Results, Result{Type: "region", Name: region.Name, ID: id})
+		}
+	}
+	for id, c := range data.Constellations {
+		if len(ret.Results) > 50 {
+			break
+		}
+		if match(c.Lower) {
+			ret.Results = append(ret.Results, Result{Type: "constellation", Name: c.Name, ID: id})
+		}
+	}
+	for id, sys := range data.Systems {
+		if len(ret.Results) > 50 {
+			break
+		}
+		if match(sys.Lower) {
+			ret.Results = append(ret.Results, Result{Type: "system", Name: sys.Name, ID: id})
+		}
+	}
 	return ret, nil
 }
 